@@ -13,6 +13,9 @@ func main() {
 	router := gin.Default()
 	// Add this line to start cleanup goroutine
 	go handlers.CleanupSessions()
+	if err := handlers.CheckAuthMode(); err != nil {
+		log.Fatal("Invalid authentication settings: ", err)
+	}
 	// Create logs directory if not exists
 	if err := os.MkdirAll("logs", 0755); err != nil {
 		log.Fatal("Failed to create logs directory: ", err)
@@ -20,6 +23,7 @@ func main() {
 
 	// Add middleware
 	router.Use(handlers.LoggingMiddleware())
+	router.Use(handlers.KubeContextMiddleware())
 	// Serve static files
 	router.StaticFile("/", "./public/index.html")
 	router.StaticFile("/dashboard", "./public/dashboard.html")
@@ -29,14 +33,59 @@ func main() {
 
 	// Handle kubeconfig upload
 	router.POST("/upload", handlers.UploadKubeConfig)
-	router.GET("/api/v1/namespaces", handlers.GetNamespaces)
-	router.GET("/api/v1/deployments/namespace/:namespace", handlers.GetDeployments)
-	router.POST("/api/v1/deployments/:namespace/rollout/:name", handlers.RolloutRestart)
-	router.GET("/api/v1/statefulsets/namespace/:namespace", handlers.GetStatefulSets)
-	router.POST("/api/v1/statefulsets/:namespace/rollout/:name", handlers.RolloutRestartStatefulSet)
-	router.GET("/api/v1/pods/namespace/:namespace", handlers.GetPods)
-	router.POST("/api/v1/pods/:namespace/rollout/:name", handlers.RolloutRestartPod)
+	router.GET("/api/v1/authmode", handlers.GetAuthMode)
+	router.POST("/api/v1/login", handlers.InClusterLogin)
+	router.POST("/api/v1/login/token", handlers.TokenLogin)
+	router.GET("/oidc/login", handlers.OIDCLogin)
+	router.GET("/oidc/callback", handlers.OIDCCallback)
+	// Only successful requests to the authenticated API count as activity
+	api := router.Group("/api/v1", handlers.SessionActivityMiddleware())
+	api.GET("/namespaces", handlers.GetNamespaces)
+	api.GET("/summaries/namespace/:namespace", handlers.AcrossClusters(handlers.GetNamespaceSummaries))
+	api.GET("/permissions/namespace/:namespace", handlers.GetPermissions)
+	api.GET("/deployments/namespace/:namespace", handlers.AcrossClusters(handlers.GetDeployments))
+	api.POST("/deployments/:namespace/rollout/:name", handlers.RolloutRestart)
+	api.GET("/deployments/:namespace/tree/:name", handlers.GetDeploymentTree)
+	api.GET("/statefulsets/namespace/:namespace", handlers.AcrossClusters(handlers.GetStatefulSets))
+	api.POST("/statefulsets/:namespace/rollout/:name", handlers.RolloutRestartStatefulSet)
+	api.GET("/statefulsets/:namespace/tree/:name", handlers.GetStatefulSetTree)
+	api.GET("/cronjobs/:namespace/tree/:name", handlers.GetCronJobTree)
+	api.GET("/pods/namespace/:namespace", handlers.AcrossClusters(handlers.GetPods))
+	api.POST("/pods/:namespace/rollout/:name", handlers.RolloutRestartPod)
+	api.GET("/nodes", handlers.AcrossClusters(handlers.GetNodes))
+	api.GET("/nodes/:name/pods", handlers.GetNodePods)
+	api.POST("/nodes/:name/cordon", handlers.CordonNode)
+	api.POST("/nodes/:name/uncordon", handlers.UncordonNode)
+	api.POST("/nodes/:name/drain", handlers.DrainNode)
+	api.GET("/drains/:id", handlers.GetDrainJob)
+	api.GET("/drains/:id/stream", handlers.StreamDrainJob)
+	api.GET("/restarts/:id", handlers.GetRestartJob)
+	api.GET("/services/namespace/:namespace", handlers.AcrossClusters(handlers.GetServices))
+	api.GET("/services/namespace/:namespace/:name", handlers.GetService)
+	api.GET("/ingresses/namespace/:namespace", handlers.AcrossClusters(handlers.GetIngresses))
+	api.GET("/ingresses/namespace/:namespace/:name", handlers.GetIngress)
+	api.GET("/hpas/namespace/:namespace", handlers.AcrossClusters(handlers.GetHPAs))
+	api.GET("/hpas/namespace/:namespace/:name", handlers.GetHPA)
+	api.PUT("/hpas/namespace/:namespace/:name/replicas", handlers.UpdateHPAReplicas)
+	api.GET("/resourcequotas/namespace/:namespace", handlers.AcrossClusters(handlers.GetResourceQuotas))
+	api.GET("/limitranges/namespace/:namespace", handlers.AcrossClusters(handlers.GetLimitRanges))
+	api.GET("/persistentvolumeclaims/namespace/:namespace", handlers.AcrossClusters(handlers.GetPersistentVolumeClaims))
+	api.GET("/persistentvolumeclaims/namespace/:namespace/:name", handlers.GetPersistentVolumeClaim)
+	api.GET("/persistentvolumes", handlers.AcrossClusters(handlers.GetPersistentVolumes))
+	api.GET("/persistentvolumes/:name", handlers.GetPersistentVolume)
+	api.GET("/configmaps/namespace/:namespace", handlers.AcrossClusters(handlers.GetConfigMaps))
+	api.GET("/configmaps/namespace/:namespace/:name", handlers.GetConfigMap)
+	api.GET("/configmaps/namespace/:namespace/:name/consumers", handlers.GetConfigMapConsumers)
+	api.POST("/configmaps/namespace/:namespace/:name/restart-consumers", handlers.RestartConfigMapConsumers)
+	api.GET("/secrets/namespace/:namespace", handlers.AcrossClusters(handlers.GetSecrets))
+	api.GET("/secrets/namespace/:namespace/:name", handlers.GetSecret)
+	api.POST("/secrets/namespace/:namespace/:name/reveal", handlers.RevealSecret)
+	api.GET("/secrets/namespace/:namespace/:name/consumers", handlers.GetSecretConsumers)
+	api.POST("/secrets/namespace/:namespace/:name/restart-consumers", handlers.RestartSecretConsumers)
 	router.GET("/api/v1/authcheck", handlers.AuthCheck)
+	router.POST("/api/v1/session/refresh", handlers.RefreshSession)
+	api.GET("/contexts", handlers.GetContexts)
+	api.POST("/contexts/switch", handlers.SwitchContext)
 	// Serve static files from the images directory
 	router.POST("/logout", handlers.Logout)
 
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditLogPath records sensitive actions separately from the access log
+const auditLogPath = "logs/audit.log"
+
+// writeAuditLog records who did what to which object, in which kubeconfig
+// context, and whether it was allowed. The user's groups and UID are those the
+// API server resolved for the session.
+func writeAuditLog(c *gin.Context, username, action, target, outcome string) {
+	kubeContext, groups, uid := "-", "-", "-"
+	if sessionToken, err := c.Cookie("sessionToken"); err == nil {
+		if session, exists := getSession(sessionToken); exists {
+			kubeContext = session.Context
+			if len(session.Groups) > 0 {
+				groups = strings.Join(session.Groups, ",")
+			}
+			if session.UID != "" {
+				uid = session.UID
+			}
+		}
+	}
+	logEntry := fmt.Sprintf(
+		"[%s] %s %s %s %s %s %s %s %s\n",
+		time.Now().Format(time.RFC3339),
+		username,
+		groups,
+		uid,
+		kubeContext,
+		c.ClientIP(),
+		action,
+		target,
+		outcome,
+	)
+
+	logFile, err := os.OpenFile(auditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+		return
+	}
+	defer logFile.Close()
+
+	if _, err := logFile.WriteString(logEntry); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// clusterKey is the gin context key holding the kubeconfig context a request
+// fanned out across clusters runs against
+const clusterKey = "kubeContext"
+
+// allClustersParam is the clusters query value that selects every context of
+// the session's kubeconfig
+const allClustersParam = "_all"
+
+// maxClusterConcurrency caps how many clusters are listed in parallel
+const maxClusterConcurrency = 4
+
+// requestContext is the kubeconfig context a request works in: the cluster
+// it was fanned out to, or else the session's active context
+func requestContext(c *gin.Context, session SessionData) string {
+	if kubeContext := c.GetString(clusterKey); kubeContext != "" {
+		return kubeContext
+	}
+	return session.Context
+}
+
+// AcrossClusters lets a list endpoint fan out over several contexts of the
+// session's kubeconfig with ?clusters=a,b or ?clusters=_all. Every item is
+// tagged with the cluster it came from, and clusters that could not be listed
+// are reported in clusterErrors. Items are grouped by cluster, each group in
+// the order the endpoint sorted it.
+func AcrossClusters(list gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		param := strings.TrimSpace(c.Query("clusters"))
+		if param == "" {
+			list(c)
+			return
+		}
+
+		sessionToken, err := c.Cookie("sessionToken")
+		session, exists := getSession(sessionToken)
+		if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		kubeconfig, err := clientcmd.Load([]byte(session.KubeconfigContent))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load kubeconfig: " + err.Error()})
+			return
+		}
+		clusters, err := parseClusters(param, kubeconfig)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Continue tokens and server-side tables belong to a single cluster
+		if c.Query("limit") != "" || c.Query("continue") != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "pagination is not supported across clusters"})
+			return
+		}
+		if c.Query("format") == "table" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format=table is not supported across clusters"})
+			return
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		pages := make(map[string]*clusterResponseWriter)
+		sem := make(chan struct{}, maxClusterConcurrency)
+		for _, cluster := range clusters {
+			wg.Add(1)
+			go func(cluster string) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				page := &clusterResponseWriter{ResponseWriter: c.Writer, header: make(http.Header), status: http.StatusOK}
+				clusterCtx := c.Copy()
+				clusterCtx.Writer = page
+				clusterCtx.Set(clusterKey, cluster)
+				list(clusterCtx)
+
+				mu.Lock()
+				pages[cluster] = page
+				mu.Unlock()
+			}(cluster)
+		}
+		wg.Wait()
+
+		response, status := mergeClusterPages(clusters, pages)
+		if status != http.StatusOK {
+			c.JSON(status, gin.H{"error": joinNamespaceErrors(response.ClusterErrors)})
+			log.Printf("Response status: %d", status)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// parseClusters expands the clusters query parameter into context names,
+// refusing names the kubeconfig does not have
+func parseClusters(param string, kubeconfig *clientcmdapi.Config) ([]string, error) {
+	var clusters []string
+	if param == allClustersParam {
+		for name := range kubeconfig.Contexts {
+			clusters = append(clusters, name)
+		}
+	} else {
+		for _, name := range strings.Split(param, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || containsString(clusters, name) {
+				continue
+			}
+			if _, ok := kubeconfig.Contexts[name]; !ok {
+				return nil, fmt.Errorf("invalid clusters: no context named %s in the kubeconfig", name)
+			}
+			clusters = append(clusters, name)
+		}
+	}
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("invalid clusters: no context selected")
+	}
+	sort.Strings(clusters)
+	return clusters, nil
+}
+
+// mergeClusterPages combines the list responses of every cluster. Namespace
+// errors are keyed by "cluster/namespace" and warnings are prefixed with the
+// cluster. The status is that of the first cluster when none could be listed.
+func mergeClusterPages(clusters []string, pages map[string]*clusterResponseWriter) (ResourceList, int) {
+	items := []map[string]interface{}{}
+	response := ResourceList{}
+	clusterErrors := make(map[string]string)
+	failedStatus := 0
+	for _, cluster := range clusters {
+		page := pages[cluster]
+		var list struct {
+			Items         []map[string]interface{} `json:"items"`
+			Errors        map[string]string        `json:"errors"`
+			LabelSelector string                   `json:"labelSelector"`
+			FieldSelector string                   `json:"fieldSelector"`
+			Warnings      []string                 `json:"warnings"`
+			Error         string                   `json:"error"`
+		}
+		if err := json.Unmarshal(page.body.Bytes(), &list); err != nil {
+			list.Error = "unreadable response: " + err.Error()
+			if page.status == http.StatusOK {
+				page.status = http.StatusInternalServerError
+			}
+		}
+		if page.status != http.StatusOK {
+			if list.Error == "" {
+				list.Error = http.StatusText(page.status)
+			}
+			clusterErrors[cluster] = list.Error
+			if failedStatus == 0 {
+				failedStatus = page.status
+			}
+			continue
+		}
+
+		for _, item := range list.Items {
+			item["cluster"] = cluster
+			items = append(items, item)
+		}
+		for namespace, msg := range list.Errors {
+			if response.Errors == nil {
+				response.Errors = make(map[string]string)
+			}
+			response.Errors[cluster+"/"+namespace] = msg
+		}
+		for _, warning := range list.Warnings {
+			response.Warnings = append(response.Warnings, cluster+": "+warning)
+		}
+		response.LabelSelector = list.LabelSelector
+		response.FieldSelector = list.FieldSelector
+	}
+
+	response.Items = items
+	if len(clusterErrors) > 0 {
+		response.ClusterErrors = clusterErrors
+	}
+	if len(clusterErrors) == len(clusters) {
+		return response, failedStatus
+	}
+	return response, http.StatusOK
+}
+
+// clusterResponseWriter captures the response a list endpoint writes for one
+// cluster so it can be merged with the others
+type clusterResponseWriter struct {
+	gin.ResponseWriter
+	header  http.Header
+	status  int
+	written bool
+	body    bytes.Buffer
+}
+
+func (w *clusterResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *clusterResponseWriter) WriteHeader(code int) {
+	if !w.written {
+		w.status = code
+	}
+}
+
+func (w *clusterResponseWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *clusterResponseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.body.Write(b)
+}
+
+func (w *clusterResponseWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+func (w *clusterResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *clusterResponseWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *clusterResponseWriter) Written() bool {
+	return w.written
+}
+
+// mergeKubeconfig adds the clusters, users and contexts of extra to base so a
+// session can hold connections from several uploads. Names already used for
+// something else get a numeric suffix, since kubeconfigs generated by the same
+// tool tend to reuse them. It returns the names the contexts were added under.
+func mergeKubeconfig(base, extra *clientcmdapi.Config) []string {
+	clusterNames := make(map[string]string)
+	for name, cluster := range extra.Clusters {
+		clusterNames[name] = mergeName(name, base.Clusters, cluster)
+		base.Clusters[clusterNames[name]] = cluster
+	}
+	userNames := make(map[string]string)
+	for name, user := range extra.AuthInfos {
+		// Users are only shared when they are the same credentials down to the
+		// last field, or contexts of the first upload would silently use the
+		// credentials of the second
+		userNames[name] = mergeName(name, base.AuthInfos, user)
+		base.AuthInfos[userNames[name]] = user
+	}
+
+	added := []string{}
+	for name, kubeContext := range extra.Contexts {
+		merged := kubeContext.DeepCopy()
+		merged.Cluster = clusterNames[kubeContext.Cluster]
+		merged.AuthInfo = userNames[kubeContext.AuthInfo]
+		name = mergeName(name, base.Contexts, merged)
+		base.Contexts[name] = merged
+		added = append(added, name)
+	}
+	sort.Strings(added)
+	return added
+}
+
+// mergeName returns name when it is free in existing or already names an
+// equal entry, and otherwise the first free name-2, name-3...
+func mergeName[T any](name string, existing map[string]T, entry T) string {
+	candidate := name
+	for i := 2; ; i++ {
+		current, taken := existing[candidate]
+		if !taken || equality.Semantic.DeepEqual(current, entry) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestMergeKubeconfig(t *testing.T) {
+	kubeconfig := func(server string, user *clientcmdapi.AuthInfo) *clientcmdapi.Config {
+		config := clientcmdapi.NewConfig()
+		config.Clusters["prod"] = &clientcmdapi.Cluster{Server: server}
+		config.AuthInfos["admin"] = user
+		config.Contexts["prod"] = &clientcmdapi.Context{Cluster: "prod", AuthInfo: "admin"}
+		return config
+	}
+	exec := func(command string) *clientcmdapi.AuthInfo {
+		return &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: command}}
+	}
+
+	tests := []struct {
+		name         string
+		base, extra  *clientcmdapi.Config
+		wantAdded    []string
+		wantCluster  string
+		wantAuthInfo string
+	}{
+		{
+			name:         "identical entries are shared",
+			base:         kubeconfig("https://prod", &clientcmdapi.AuthInfo{Token: "a"}),
+			extra:        kubeconfig("https://prod", &clientcmdapi.AuthInfo{Token: "a"}),
+			wantAdded:    []string{"prod"},
+			wantCluster:  "prod",
+			wantAuthInfo: "admin",
+		},
+		{
+			name:         "different server renames cluster and context",
+			base:         kubeconfig("https://prod", &clientcmdapi.AuthInfo{Token: "a"}),
+			extra:        kubeconfig("https://staging", &clientcmdapi.AuthInfo{Token: "a"}),
+			wantAdded:    []string{"prod-2"},
+			wantCluster:  "prod-2",
+			wantAuthInfo: "admin",
+		},
+		{
+			name:         "different token renames user",
+			base:         kubeconfig("https://prod", &clientcmdapi.AuthInfo{Token: "a"}),
+			extra:        kubeconfig("https://prod", &clientcmdapi.AuthInfo{Token: "b"}),
+			wantAdded:    []string{"prod-2"},
+			wantCluster:  "prod",
+			wantAuthInfo: "admin-2",
+		},
+		{
+			name:         "same username with different password renames user",
+			base:         kubeconfig("https://prod", &clientcmdapi.AuthInfo{Username: "admin", Password: "a"}),
+			extra:        kubeconfig("https://prod", &clientcmdapi.AuthInfo{Username: "admin", Password: "b"}),
+			wantAdded:    []string{"prod-2"},
+			wantCluster:  "prod",
+			wantAuthInfo: "admin-2",
+		},
+		{
+			name:         "same certificate with different key renames user",
+			base:         kubeconfig("https://prod", &clientcmdapi.AuthInfo{ClientCertificateData: []byte("cert"), ClientKeyData: []byte("a")}),
+			extra:        kubeconfig("https://prod", &clientcmdapi.AuthInfo{ClientCertificateData: []byte("cert"), ClientKeyData: []byte("b")}),
+			wantAdded:    []string{"prod-2"},
+			wantCluster:  "prod",
+			wantAuthInfo: "admin-2",
+		},
+		{
+			name:         "different exec plugin renames user",
+			base:         kubeconfig("https://prod", exec("aws")),
+			extra:        kubeconfig("https://prod", exec("gke-gcloud-auth-plugin")),
+			wantAdded:    []string{"prod-2"},
+			wantCluster:  "prod",
+			wantAuthInfo: "admin-2",
+		},
+		{
+			name:         "different auth provider config renames user",
+			base:         kubeconfig("https://prod", &clientcmdapi.AuthInfo{AuthProvider: &clientcmdapi.AuthProviderConfig{Name: "oidc", Config: map[string]string{"id-token": "a"}}}),
+			extra:        kubeconfig("https://prod", &clientcmdapi.AuthInfo{AuthProvider: &clientcmdapi.AuthProviderConfig{Name: "oidc", Config: map[string]string{"id-token": "b"}}}),
+			wantAdded:    []string{"prod-2"},
+			wantCluster:  "prod",
+			wantAuthInfo: "admin-2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseUser := test.base.AuthInfos["admin"].DeepCopy()
+			added := mergeKubeconfig(test.base, test.extra)
+			if !reflect.DeepEqual(added, test.wantAdded) {
+				t.Fatalf("added %v, want %v", added, test.wantAdded)
+			}
+			merged := test.base.Contexts[added[0]]
+			if merged.Cluster != test.wantCluster || merged.AuthInfo != test.wantAuthInfo {
+				t.Errorf("context uses %s/%s, want %s/%s", merged.Cluster, merged.AuthInfo, test.wantCluster, test.wantAuthInfo)
+			}
+			// The first upload's context must keep its own credentials
+			if !reflect.DeepEqual(test.base.AuthInfos[test.base.Contexts["prod"].AuthInfo], baseUser) {
+				t.Errorf("the first upload's user was overwritten")
+			}
+		})
+	}
+}
+
+func TestMergeKubeconfigTakenSuffix(t *testing.T) {
+	base := clientcmdapi.NewConfig()
+	base.Clusters["prod"] = &clientcmdapi.Cluster{Server: "https://a"}
+	base.Clusters["prod-2"] = &clientcmdapi.Cluster{Server: "https://b"}
+	extra := clientcmdapi.NewConfig()
+	extra.Clusters["prod"] = &clientcmdapi.Cluster{Server: "https://c"}
+	extra.AuthInfos["admin"] = &clientcmdapi.AuthInfo{Token: "a"}
+	extra.Contexts["prod"] = &clientcmdapi.Context{Cluster: "prod", AuthInfo: "admin"}
+
+	added := mergeKubeconfig(base, extra)
+	if got := base.Contexts[added[0]].Cluster; got != "prod-3" {
+		t.Errorf("cluster merged as %s, want prod-3", got)
+	}
+	if base.Clusters["prod-3"].Server != "https://c" {
+		t.Errorf("prod-3 points at %s", base.Clusters["prod-3"].Server)
+	}
+}
+
+func TestAppendKubeConfig(t *testing.T) {
+	expiry := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"jane","exp":%d}`, expiry.Unix())))
+	expiringToken := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`)) + "." + claims + "." + base64.RawURLEncoding.EncodeToString([]byte("signature"))
+
+	sessionToken := newSessionToken()
+	t.Cleanup(func() { deleteSession(sessionToken) })
+	// client-go only sends tokens over TLS
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v1/namespaces" {
+			http.NotFound(w, r)
+			return
+		}
+		// The session switches context while the upload is checked
+		updateSession(sessionToken, func(session *SessionData) error {
+			session.Context = "prod"
+			return nil
+		})
+		w.Write([]byte(`{"kind":"NamespaceList","apiVersion":"v1","items":[]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	kubeconfig := func(token string, names ...string) []byte {
+		config := clientcmdapi.NewConfig()
+		for _, name := range names {
+			config.Clusters[name] = &clientcmdapi.Cluster{Server: server.URL, InsecureSkipTLSVerify: true}
+			config.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: token}
+			config.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+			config.CurrentContext = name
+		}
+		content, err := clientcmd.Write(*config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return content
+	}
+	now := time.Now()
+	saveSession(sessionToken, SessionData{KubeconfigContent: string(kubeconfig(expiringToken, "dev", "prod")), Context: "dev", CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+	permissionsMu.Lock()
+	permissionsCache[sessionToken] = map[string]*sessionPermissions{"default": {expiresAt: now.Add(time.Hour)}}
+	permissionsMu.Unlock()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("append", "true")
+	file, _ := form.CreateFormFile("kubeconfig", "staging.yaml")
+	file.Write(kubeconfig("opaque", "staging"))
+	form.Close()
+	router := gin.New()
+	router.POST("/upload", UploadKubeConfig)
+	request := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	request.AddCookie(&http.Cookie{Name: "sessionToken", Value: sessionToken})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	session, _ := getSession(sessionToken)
+	if session.Context != "prod" {
+		t.Errorf("the upload overwrote the context switch: session is in %s", session.Context)
+	}
+	if !strings.Contains(session.KubeconfigContent, "staging") {
+		t.Errorf("the uploaded context was not added")
+	}
+	if session.CredentialsExpireAt == nil || !session.CredentialsExpireAt.Equal(expiry) {
+		t.Errorf("credentials expire at %v, want %v", session.CredentialsExpireAt, expiry)
+	}
+	permissionsMu.Lock()
+	_, cached := permissionsCache[sessionToken]
+	permissionsMu.Unlock()
+	if cached {
+		t.Errorf("permissions of the previous credentials are still cached")
+	}
+}
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// secretRevealUsersEnv lists the usernames, comma-separated, who may reveal
+// secret values on top of Kubernetes RBAC. Nobody may when it is unset;
+// secretRevealEveryone leaves the decision to RBAC alone.
+const secretRevealUsersEnv = "SECRET_REVEAL_USERS"
+
+const secretRevealEveryone = "*"
+
+// KeyInfo describes a ConfigMap or Secret key without its value
+type KeyInfo struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	Binary bool   `json:"binary,omitempty"`
+}
+
+type ConfigMapResource struct {
+	Name         string            `json:"name"`
+	Namespace    string            `json:"namespace"`
+	Keys         []KeyInfo         `json:"keys"`
+	UsedBy       []WorkloadRef     `json:"usedBy"`
+	Age          string            `json:"age"`
+	Labels       map[string]string `json:"labels"`
+	ResourceType string            `json:"resourceType"`
+
+	createdAt time.Time
+}
+
+func (r ConfigMapResource) sortKey() sortKey {
+	return sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt}
+}
+
+type ConfigMapDetail struct {
+	ConfigMapResource
+	Data map[string]string `json:"data"`
+}
+
+type SecretResource struct {
+	Name         string            `json:"name"`
+	Namespace    string            `json:"namespace"`
+	Type         string            `json:"type"`
+	Keys         []KeyInfo         `json:"keys"`
+	UsedBy       []WorkloadRef     `json:"usedBy"`
+	Age          string            `json:"age"`
+	Labels       map[string]string `json:"labels"`
+	ResourceType string            `json:"resourceType"`
+
+	createdAt time.Time
+}
+
+func (r SecretResource) sortKey() sortKey {
+	return sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt}
+}
+
+// GetConfigMaps lists ConfigMaps with their key names and sizes and the
+// workloads that use them
+func GetConfigMaps(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	request, ok := parseListRequest(c, "age")
+	if !ok {
+		return
+	}
+
+	respondList(c, clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[ConfigMapResource], error) {
+		configMaps, err := clientset.CoreV1().ConfigMaps(namespace).List(context.TODO(), opts)
+		if err != nil {
+			return namespacePage[ConfigMapResource]{}, err
+		}
+		page := namespacePage[ConfigMapResource]{meta: configMaps.ListMeta}
+		// Usage is optional: the ConfigMaps are still listed without it
+		workloads, err := listWorkloads(clientset, namespace)
+		if err != nil {
+			page.warnings = append(page.warnings, "Failed to find workloads using ConfigMaps: "+err.Error())
+		}
+		for _, cm := range configMaps.Items {
+			page.items = append(page.items, configMapResource(cm, workloads))
+		}
+		return page, nil
+	})
+}
+
+// GetConfigMap returns a ConfigMap with its values. Binary values are only
+// described by their size.
+func GetConfigMap(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	namespace := c.Param("namespace")
+
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), c.Param("name"), metav1.GetOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+	workloads, err := listWorkloads(clientset, namespace)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	data := cm.Data
+	if data == nil {
+		data = map[string]string{}
+	}
+	c.JSON(http.StatusOK, ConfigMapDetail{
+		ConfigMapResource: configMapResource(*cm, workloads),
+		Data:              data,
+	})
+}
+
+// GetSecrets lists Secrets with their key names and sizes, never their values
+func GetSecrets(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	request, ok := parseListRequest(c, "age")
+	if !ok {
+		return
+	}
+
+	respondList(c, clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[SecretResource], error) {
+		secrets, err := clientset.CoreV1().Secrets(namespace).List(context.TODO(), opts)
+		if err != nil {
+			return namespacePage[SecretResource]{}, err
+		}
+		page := namespacePage[SecretResource]{meta: secrets.ListMeta}
+		// Usage is optional: the Secrets are still listed without it
+		workloads, err := listWorkloads(clientset, namespace)
+		if err != nil {
+			page.warnings = append(page.warnings, "Failed to find workloads using Secrets: "+err.Error())
+		}
+		for _, secret := range secrets.Items {
+			page.items = append(page.items, secretResource(secret, workloads))
+		}
+		return page, nil
+	})
+}
+
+// GetSecret describes a Secret with its values redacted. Use RevealSecret to
+// read the values.
+func GetSecret(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	namespace := c.Param("namespace")
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(context.TODO(), c.Param("name"), metav1.GetOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+	workloads, err := listWorkloads(clientset, namespace)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, secretResource(*secret, workloads))
+}
+
+// RevealSecret returns the decoded values of a Secret, or of the keys given in
+// ?key=. It is authorized separately from browsing: the user must be allowed
+// to get this very Secret and be listed in SECRET_REVEAL_USERS. Every attempt
+// is written to the audit log, whatever its outcome.
+func RevealSecret(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	namespace := c.Param("namespace")
+	secretName := c.Param("name")
+	target := "secret/" + namespace + "/" + secretName
+	username := session.Username
+
+	if !secretRevealAllowedUser(username) {
+		writeAuditLog(c, username, "secret.reveal", target, "denied: not in "+secretRevealUsersEnv)
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to reveal secret values, ask an administrator to add you to " + secretRevealUsersEnv})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		writeAuditLog(c, username, "secret.reveal", target, "failed: "+err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+
+	allowed, err := canGetSecret(clientset, namespace, secretName)
+	if err != nil {
+		writeAuditLog(c, username, "secret.reveal", target, "failed: "+err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		writeAuditLog(c, username, "secret.reveal", target, "denied: RBAC")
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to get this secret"})
+		return
+	}
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+	if err != nil {
+		writeAuditLog(c, username, "secret.reveal", target, "failed: "+err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	keys := c.QueryArray("key")
+	data := make(map[string]string)
+	// Values that are not text are returned base64 encoded, as in the API
+	binaryData := make(map[string]string)
+	var revealed []string
+	for key, value := range secret.Data {
+		if len(keys) > 0 && !containsString(keys, key) {
+			continue
+		}
+		if utf8.Valid(value) {
+			data[key] = string(value)
+		} else {
+			binaryData[key] = base64.StdEncoding.EncodeToString(value)
+		}
+		revealed = append(revealed, key)
+	}
+	sort.Strings(revealed)
+
+	writeAuditLog(c, username, "secret.reveal", target, "allowed: keys="+strings.Join(revealed, ","))
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"name":       secret.Name,
+		"namespace":  secret.Namespace,
+		"data":       data,
+		"binaryData": binaryData,
+	})
+}
+
+func secretRevealAllowedUser(username string) bool {
+	for _, user := range strings.Split(os.Getenv(secretRevealUsersEnv), ",") {
+		user = strings.TrimSpace(user)
+		if user != "" && (user == secretRevealEveryone || user == username) {
+			return true
+		}
+	}
+	return false
+}
+
+// canGetSecret asks the API server whether the session's user may get this
+// particular Secret, which being able to list Secrets does not imply
+func canGetSecret(clientset *kubernetes.Clientset, namespace, name string) (bool, error) {
+	review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "get",
+				Resource:  "secrets",
+				Name:      name,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// configMapResource computes the dashboard's columns for a single ConfigMap
+func configMapResource(cm corev1.ConfigMap, workloads []workload) ConfigMapResource {
+	keys := []KeyInfo{}
+	for key, value := range cm.Data {
+		keys = append(keys, KeyInfo{Name: key, Size: len(value)})
+	}
+	for key, value := range cm.BinaryData {
+		keys = append(keys, KeyInfo{Name: key, Size: len(value), Binary: true})
+	}
+	sortKeys(keys)
+
+	return ConfigMapResource{
+		Name:         cm.Name,
+		Namespace:    cm.Namespace,
+		Keys:         keys,
+		UsedBy:       workloadsReferencing(workloads, cm.Namespace, cm.Name, configMapRefs),
+		Age:          formatDuration(time.Since(cm.CreationTimestamp.Time)),
+		Labels:       cm.Labels,
+		ResourceType: "ConfigMap",
+		createdAt:    cm.CreationTimestamp.Time,
+	}
+}
+
+// secretResource computes the dashboard's columns for a single Secret. Only
+// the sizes of its values are kept.
+func secretResource(secret corev1.Secret, workloads []workload) SecretResource {
+	keys := []KeyInfo{}
+	for key, value := range secret.Data {
+		keys = append(keys, KeyInfo{Name: key, Size: len(value), Binary: !utf8.Valid(value)})
+	}
+	sortKeys(keys)
+
+	return SecretResource{
+		Name:         secret.Name,
+		Namespace:    secret.Namespace,
+		Type:         string(secret.Type),
+		Keys:         keys,
+		UsedBy:       workloadsReferencing(workloads, secret.Namespace, secret.Name, secretRefs),
+		Age:          formatDuration(time.Since(secret.CreationTimestamp.Time)),
+		Labels:       secret.Labels,
+		ResourceType: "Secret",
+		createdAt:    secret.CreationTimestamp.Time,
+	}
+}
+
+func sortKeys(keys []KeyInfo) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+}
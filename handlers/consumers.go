@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxRestartPause bounds the wait between batches, so a restart job with
+// many batches still finishes in reasonable time
+const maxRestartPause = 5 * time.Minute
+
+const (
+	RestartRunning   = "Running"
+	RestartSucceeded = "Succeeded"
+	RestartFailed    = "Failed"
+)
+
+// restarters are the workload kinds that can be restarted by changing their
+// pod template. CronJobs pick up changes on their next run instead.
+var restarters = map[string]func(clientset *kubernetes.Clientset, namespace, name string) error{
+	"Deployment":  restartDeployment,
+	"StatefulSet": restartStatefulSet,
+	"DaemonSet":   restartDaemonSet,
+}
+
+// RestartConsumersOptions controls how the consumers of a ConfigMap or Secret
+// are restarted. Workloads, as "Kind/name", limits the restart to a subset of
+// the preview; when empty every consumer is restarted.
+type RestartConsumersOptions struct {
+	BatchSize    int      `json:"batchSize"`
+	PauseSeconds int      `json:"pauseSeconds"`
+	Workloads    []string `json:"workloads"`
+}
+
+// RestartResult is the outcome of restarting one workload. Status is
+// "pending" until its batch runs, then "restarted", "failed" or "skipped"
+// when an earlier batch failed.
+type RestartResult struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Batch  int    `json:"batch"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RestartJob is the progress of an asynchronous restart of the consumers of a
+// ConfigMap or Secret, named as kind/namespace/name in Target
+type RestartJob struct {
+	ID         string          `json:"id"`
+	Target     string          `json:"target"`
+	Status     string          `json:"status"`
+	Items      []RestartResult `json:"items"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+
+	sessionToken string
+	kind         string
+	namespace    string
+}
+
+var (
+	restartJobs   = make(map[string]*RestartJob)
+	restartJobsMu sync.Mutex
+)
+
+func GetConfigMapConsumers(c *gin.Context) {
+	getConsumers(c, configMapRefs)
+}
+
+func GetSecretConsumers(c *gin.Context) {
+	getConsumers(c, secretRefs)
+}
+
+func RestartConfigMapConsumers(c *gin.Context) {
+	restartConsumers(c, "configmap", configMapRefs)
+}
+
+func RestartSecretConsumers(c *gin.Context) {
+	restartConsumers(c, "secret", secretRefs)
+}
+
+// getConsumers previews the workloads a restart would roll
+func getConsumers(c *gin.Context, refs func(w workload) map[string][]string) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+
+	consumers, err := restartableConsumers(clientset, c.Param("namespace"), c.Param("name"), refs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": consumers})
+}
+
+// restartConsumers restarts the consumers batchSize at a time in the
+// background, pausing between batches. It responds with the job ID to poll
+// with GetRestartJob.
+func restartConsumers(c *gin.Context, kind string, refs func(w workload) map[string][]string) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+
+	var opts RestartConsumersOptions
+	if err := c.ShouldBindJSON(&opts); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid restart options: " + err.Error()})
+		return
+	}
+	if opts.BatchSize < 0 || opts.PauseSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid restart options: batchSize and pauseSeconds must not be negative"})
+		return
+	}
+	pause := time.Duration(opts.PauseSeconds) * time.Second
+	if pause > maxRestartPause {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid restart options: pauseSeconds must be at most " + maxRestartPause.String()})
+		return
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 1
+	}
+
+	namespace := c.Param("namespace")
+	name := c.Param("name")
+	consumers, err := restartableConsumers(clientset, namespace, name, refs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+	if len(opts.Workloads) > 0 {
+		selected := []WorkloadRef{}
+		previewed := []string{}
+		for _, consumer := range consumers {
+			previewed = append(previewed, consumer.Kind+"/"+consumer.Name)
+			if containsString(opts.Workloads, consumer.Kind+"/"+consumer.Name) {
+				selected = append(selected, consumer)
+			}
+		}
+		// A workload missing from the preview is a typo or a stale page, and
+		// restarting the rest without it would go unnoticed
+		var unknown []string
+		for _, workload := range opts.Workloads {
+			if !containsString(previewed, workload) && !containsString(unknown, workload) {
+				unknown = append(unknown, workload)
+			}
+		}
+		if len(unknown) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid restart options: not restartable consumers of " + name + ": " + strings.Join(unknown, ", ")})
+			return
+		}
+		consumers = selected
+	}
+
+	// Each workload is checked again when its batch runs, as permissions may
+	// change while the job waits
+	var checked []string
+	for _, consumer := range consumers {
+		if containsString(checked, consumer.Kind) {
+			continue
+		}
+		checked = append(checked, consumer.Kind)
+		if !requireJobPermission(c, clientset, sessionToken, namespace, strings.ToLower(consumer.Kind)+"s.restart") {
+			return
+		}
+	}
+
+	job := &RestartJob{
+		ID:           newJobID(),
+		Target:       kind + "/" + namespace + "/" + name,
+		Status:       RestartRunning,
+		Items:        []RestartResult{},
+		StartedAt:    time.Now(),
+		sessionToken: sessionToken,
+		kind:         kind,
+		namespace:    namespace,
+	}
+	for i, consumer := range consumers {
+		job.Items = append(job.Items, RestartResult{Kind: consumer.Kind, Name: consumer.Name, Batch: i/opts.BatchSize + 1, Status: "pending"})
+	}
+	restartJobsMu.Lock()
+	restartJobs[job.ID] = job
+	restartJobsMu.Unlock()
+
+	// The copy outlives the request, for the audit record written at the end
+	go runRestart(c.Copy(), clientset, job, session.Username, opts.BatchSize, pause)
+
+	c.JSON(http.StatusAccepted, gin.H{"id": job.ID})
+}
+
+// GetRestartJob returns the current progress of a restart job
+func GetRestartJob(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	job, ok := restartJobSnapshot(c.Param("id"), sessionToken)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Restart job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// runRestart restarts the job's workloads batch by batch. A batch with a
+// failure stops the remaining batches.
+func runRestart(c *gin.Context, clientset *kubernetes.Clientset, job *RestartJob, username string, batchSize int, pause time.Duration) {
+	failed := false
+	for i := range job.Items {
+		result := job.Items[i]
+		if failed {
+			result.Status = "skipped"
+			updateRestartResult(job, i, result)
+			continue
+		}
+		if i > 0 && i%batchSize == 0 {
+			time.Sleep(pause)
+		}
+		// Workloads the session may not update, or whose permission cannot
+		// be checked, fail without trying
+		allowed, err := sessionAllowed(clientset, job.sessionToken, job.namespace, dashboardActions[strings.ToLower(result.Kind)+"s.restart"])
+		if err != nil {
+			result.Status = "failed"
+			result.Error = "permission check failed: " + err.Error()
+		} else if !allowed {
+			result.Status = "failed"
+			result.Error = "forbidden"
+		} else if err := restarters[result.Kind](clientset, job.namespace, result.Name); err != nil {
+			result.Status = "failed"
+			result.Error = err.Error()
+		} else {
+			result.Status = "restarted"
+		}
+		updateRestartResult(job, i, result)
+		// The rest of a batch still runs, but no further batch starts
+		if (i+1)%batchSize == 0 {
+			failed = batchFailed(job.Items[:i+1], result.Batch)
+		}
+	}
+
+	var restarted []string
+	status := RestartSucceeded
+	restartJobsMu.Lock()
+	for _, result := range job.Items {
+		switch result.Status {
+		case "restarted":
+			restarted = append(restarted, result.Kind+"/"+result.Name)
+		case "failed", "skipped":
+			status = RestartFailed
+		}
+	}
+	now := time.Now()
+	job.Status = status
+	job.FinishedAt = &now
+	restartJobsMu.Unlock()
+
+	writeAuditLog(c, username, job.kind+".restart-consumers", job.Target, "restarted: "+strings.Join(restarted, ","))
+}
+
+func updateRestartResult(job *RestartJob, i int, result RestartResult) {
+	restartJobsMu.Lock()
+	defer restartJobsMu.Unlock()
+	job.Items[i] = result
+}
+
+// restartJobSnapshot returns a copy of a job, only to the session that started it
+func restartJobSnapshot(id, sessionToken string) (RestartJob, bool) {
+	restartJobsMu.Lock()
+	defer restartJobsMu.Unlock()
+	job, ok := restartJobs[id]
+	if !ok || job.sessionToken != sessionToken {
+		return RestartJob{}, false
+	}
+	snapshot := *job
+	snapshot.Items = append([]RestartResult{}, job.Items...)
+	return snapshot, true
+}
+
+// cleanupRestartJobs forgets restart jobs that finished more than
+// drainJobRetention ago
+func cleanupRestartJobs() {
+	restartJobsMu.Lock()
+	defer restartJobsMu.Unlock()
+	for id, job := range restartJobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > drainJobRetention {
+			delete(restartJobs, id)
+		}
+	}
+}
+
+// restartableConsumers returns the Deployments, StatefulSets and DaemonSets
+// in namespace whose pod templates reference name
+func restartableConsumers(clientset *kubernetes.Clientset, namespace, name string, refs func(w workload) map[string][]string) ([]WorkloadRef, error) {
+	workloads, err := listWorkloads(clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+	consumers := []WorkloadRef{}
+	for _, ref := range workloadsReferencing(workloads, namespace, name, refs) {
+		if _, ok := restarters[ref.Kind]; ok {
+			consumers = append(consumers, ref)
+		}
+	}
+	return consumers, nil
+}
+
+func batchFailed(results []RestartResult, batch int) bool {
+	for _, result := range results {
+		if result.Batch == batch && result.Status == "failed" {
+			return true
+		}
+	}
+	return false
+}
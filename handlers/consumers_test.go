@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// consumersAPIServer serves the Deployments web and api, both reading the
+// ConfigMap app, and records the ones updated. The rules review answers with
+// reviewStatus, granting updates on Deployments when it succeeds.
+func consumersAPIServer(t *testing.T, reviewStatus int) (*httptest.Server, func() []string) {
+	deployment := func(name string) appsv1.Deployment {
+		return appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:    "app",
+				EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app"}}}},
+			}}}}},
+		}
+	}
+
+	var mu sync.Mutex
+	var updated []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		name := strings.TrimPrefix(r.URL.Path, "/apis/apps/v1/namespaces/default/deployments/")
+		switch {
+		case r.URL.Path == "/apis/authorization.k8s.io/v1/selfsubjectrulesreviews":
+			w.WriteHeader(reviewStatus)
+			if reviewStatus != http.StatusCreated {
+				json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Message: "authorizer unavailable", Code: int32(reviewStatus)})
+				return
+			}
+			json.NewEncoder(w).Encode(authorizationv1.SelfSubjectRulesReview{
+				TypeMeta: metav1.TypeMeta{Kind: "SelfSubjectRulesReview", APIVersion: "authorization.k8s.io/v1"},
+				Status: authorizationv1.SubjectRulesReviewStatus{ResourceRules: []authorizationv1.ResourceRule{
+					{Verbs: []string{"update"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}},
+				}},
+			})
+		case r.URL.Path == "/apis/apps/v1/namespaces/default/deployments":
+			json.NewEncoder(w).Encode(appsv1.DeploymentList{TypeMeta: metav1.TypeMeta{Kind: "DeploymentList", APIVersion: "apps/v1"}, Items: []appsv1.Deployment{deployment("web"), deployment("api")}})
+		case name == "web" || name == "api":
+			if r.Method == http.MethodPut {
+				mu.Lock()
+				updated = append(updated, name)
+				mu.Unlock()
+			}
+			json.NewEncoder(w).Encode(deployment(name))
+		default:
+			// No StatefulSets, DaemonSets or CronJobs
+			w.Write([]byte(`{"items":[]}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, updated...)
+	}
+}
+
+// consumersSession saves a session whose kubeconfig points at server
+func consumersSession(t *testing.T, server *httptest.Server) string {
+	sessionToken := testSession(t, server.URL)
+	t.Cleanup(func() { forgetPermissions(sessionToken) })
+	return sessionToken
+}
+
+func TestRestartConsumers(t *testing.T) {
+	tests := []struct {
+		name         string
+		reviewStatus int
+		workloads    string
+		wantStatus   int
+		wantUnknown  []string
+	}{
+		{name: "previewed workloads", reviewStatus: http.StatusCreated, workloads: `["Deployment/web"]`, wantStatus: http.StatusAccepted},
+		{name: "unknown workloads", reviewStatus: http.StatusCreated, workloads: `["Deployment/web","Deployment/gone","StatefulSet/api"]`, wantStatus: http.StatusBadRequest, wantUnknown: []string{"Deployment/gone", "StatefulSet/api"}},
+		{name: "permission check fails", reviewStatus: http.StatusInternalServerError, workloads: `[]`, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, updated := consumersAPIServer(t, test.reviewStatus)
+			sessionToken := consumersSession(t, server)
+			router := gin.New()
+			router.POST("/configmaps/namespace/:namespace/:name/restart-consumers", RestartConfigMapConsumers)
+			request := httptest.NewRequest(http.MethodPost, "/configmaps/namespace/default/app/restart-consumers", strings.NewReader(`{"workloads":`+test.workloads+`}`))
+			request.Header.Set("Content-Type", "application/json")
+			request.AddCookie(&http.Cookie{Name: "sessionToken", Value: sessionToken})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+
+			if w.Code != test.wantStatus {
+				t.Fatalf("got %d: %s", w.Code, w.Body.String())
+			}
+			for _, unknown := range test.wantUnknown {
+				if !strings.Contains(w.Body.String(), unknown) {
+					t.Errorf("error %s does not name %s", w.Body.String(), unknown)
+				}
+			}
+			if strings.Contains(w.Body.String(), "Deployment/web\"") {
+				t.Errorf("error names a previewed workload: %s", w.Body.String())
+			}
+			if test.wantStatus != http.StatusAccepted && len(updated()) > 0 {
+				t.Errorf("restarted %v", updated())
+			}
+		})
+	}
+}
+
+func TestRunRestartPermissionCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		reviewStatus int
+		wantStatus   string
+		wantError    string
+		wantUpdated  int
+	}{
+		{name: "allowed", reviewStatus: http.StatusCreated, wantStatus: "restarted", wantUpdated: 2},
+		{name: "check fails", reviewStatus: http.StatusInternalServerError, wantStatus: "failed", wantError: "permission check failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, updated := consumersAPIServer(t, test.reviewStatus)
+			clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL, QPS: 1000, Burst: 1000})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessionToken := "test-" + t.Name()
+			t.Cleanup(func() { forgetPermissions(sessionToken) })
+			job := &RestartJob{
+				Target:       "configmap/default/app",
+				Status:       RestartRunning,
+				Items:        []RestartResult{{Kind: "Deployment", Name: "web", Batch: 1, Status: "pending"}, {Kind: "Deployment", Name: "api", Batch: 1, Status: "pending"}},
+				sessionToken: sessionToken,
+				kind:         "configmap",
+				namespace:    "default",
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+
+			runRestart(c, clientset, job, "jane", 2, 0)
+
+			for _, item := range job.Items {
+				if item.Status != test.wantStatus || !strings.Contains(item.Error, test.wantError) {
+					t.Errorf("%s: got %s %q, want %s %q", item.Name, item.Status, item.Error, test.wantStatus, test.wantError)
+				}
+			}
+			if got := updated(); len(got) != test.wantUpdated {
+				t.Errorf("updated %v, want %d Deployments", got, test.wantUpdated)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubeContextHeader names the active kubeconfig context on every response
+const kubeContextHeader = "X-Kube-Context"
+
+// KubeContext is a context of the session's kubeconfig
+type KubeContext struct {
+	Name      string `json:"name"`
+	Cluster   string `json:"cluster"`
+	User      string `json:"user"`
+	Namespace string `json:"namespace,omitempty"`
+	Active    bool   `json:"active"`
+}
+
+// KubeContextMiddleware sets the X-Kube-Context header to the session's
+// active context, so clients always know which cluster answered
+func KubeContextMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if sessionToken, err := c.Cookie("sessionToken"); err == nil {
+			if session, exists := getSession(sessionToken); exists {
+				c.Header(kubeContextHeader, session.Context)
+			}
+		}
+		c.Next()
+	}
+}
+
+// GetContexts lists the contexts of the uploaded kubeconfig
+func GetContexts(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	// In-cluster sessions have no kubeconfig, only the dashboard's own cluster
+	if session.KubeconfigContent == "" {
+		c.JSON(http.StatusOK, []KubeContext{{Name: session.Context, Cluster: session.Context, User: session.Username, Active: true}})
+		return
+	}
+	kubeconfig, err := clientcmd.Load([]byte(session.KubeconfigContent))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load kubeconfig: " + err.Error()})
+		return
+	}
+
+	contexts := []KubeContext{}
+	for name, kubeContext := range kubeconfig.Contexts {
+		contexts = append(contexts, KubeContext{
+			Name:      name,
+			Cluster:   kubeContext.Cluster,
+			User:      kubeContext.AuthInfo,
+			Namespace: kubeContext.Namespace,
+			Active:    name == session.Context,
+		})
+	}
+	sort.Slice(contexts, func(i, j int) bool {
+		return contexts[i].Name < contexts[j].Name
+	})
+
+	c.JSON(http.StatusOK, contexts)
+}
+
+// SwitchContext makes another context of the kubeconfig the active one. The
+// context must be reachable before the session switches to it.
+func SwitchContext(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		Context string `json:"context"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	kubeconfig, err := clientcmd.Load([]byte(session.KubeconfigContent))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load kubeconfig: " + err.Error()})
+		return
+	}
+	kubeContext, ok := kubeconfig.Contexts[request.Context]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No context named " + request.Context + " in the kubeconfig"})
+		return
+	}
+
+	clientset, err := validateKubeConfig(session.KubeconfigContent, request.Context)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid context: " + err.Error()})
+		return
+	}
+	if err := checkCredentials(clientset); apierrors.IsUnauthorized(err) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid context: the API server does not accept its credentials"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to interact with cluster: " + err.Error()})
+		return
+	}
+
+	identity := kubeconfigIdentity(clientset, session.KubeconfigContent, request.Context, kubeContext.AuthInfo)
+	// Renewals and token refreshes may have changed the session meanwhile
+	session, exists, err = updateSession(sessionToken, func(session *SessionData) error {
+		session.Context = request.Context
+		session.Username = identity.Username
+		session.Groups = identity.Groups
+		session.UID = identity.UID
+		return capSessionExpiry(session)
+	})
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid context: " + err.Error()})
+		return
+	}
+	// The new context's credentials may outlive the old ones
+	c.SetCookie("sessionToken", sessionToken, sessionCookieMaxAge(session), "/", "", false, true)
+	// Permissions were answered by the previous cluster
+	forgetPermissions(sessionToken)
+
+	c.Header(kubeContextHeader, session.Context)
+	c.JSON(http.StatusOK, gin.H{
+		"context":   session.Context,
+		"user":      session.Username,
+		"namespace": kubeContext.Namespace,
+		"warning":   credentialWarning(session),
+	})
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// contextsAPIServer answers the credential check and SelfSubjectReview of a
+// context switch. duringReview runs while the credentials are checked, like
+// a request the session serves concurrently.
+func contextsAPIServer(t *testing.T, reviewStatus int, duringReview func()) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews":
+			duringReview()
+			if reviewStatus != http.StatusCreated {
+				w.WriteHeader(reviewStatus)
+				json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Reason: metav1.StatusReasonUnauthorized, Code: int32(reviewStatus)})
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"apiVersion":"authorization.k8s.io/v1","kind":"SelfSubjectAccessReview","status":{"allowed":true}}`))
+		case "/apis/authentication.k8s.io/v1beta1/selfsubjectreviews":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"apiVersion":"authentication.k8s.io/v1beta1","kind":"SelfSubjectReview","status":{"userInfo":{"username":"jane","groups":["developers"]}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// contextsKubeconfig has a context per name, all pointing at server
+func contextsKubeconfig(t *testing.T, server string, names ...string) string {
+	kubeconfig := clientcmdapi.NewConfig()
+	for _, name := range names {
+		kubeconfig.Clusters[name] = &clientcmdapi.Cluster{Server: server, InsecureSkipTLSVerify: true}
+		kubeconfig.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: "token-" + name}
+		kubeconfig.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+	}
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestSwitchContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		context      string
+		reviewStatus int
+		wantStatus   int
+		wantContext  string
+	}{
+		{name: "switches", context: "b", reviewStatus: http.StatusCreated, wantStatus: http.StatusOK, wantContext: "b"},
+		{name: "unknown context", context: "c", reviewStatus: http.StatusCreated, wantStatus: http.StatusNotFound, wantContext: "a"},
+		{name: "rejected credentials", context: "b", reviewStatus: http.StatusUnauthorized, wantStatus: http.StatusUnauthorized, wantContext: "a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sessionToken := newSessionToken()
+			t.Cleanup(func() { deleteSession(sessionToken) })
+			var added string
+			server := contextsAPIServer(t, test.reviewStatus, func() {
+				// Another tab adds a kubeconfig while the switch is checked
+				updateSession(sessionToken, func(session *SessionData) error {
+					session.KubeconfigContent = added
+					return nil
+				})
+			})
+			added = contextsKubeconfig(t, server.URL, "a", "b", "added")
+			now := time.Now()
+			saveSession(sessionToken, SessionData{KubeconfigContent: contextsKubeconfig(t, server.URL, "a", "b"), Context: "a", Username: "a", CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+
+			router := gin.New()
+			router.POST("/contexts/switch", SwitchContext)
+			request := httptest.NewRequest(http.MethodPost, "/contexts/switch", strings.NewReader(`{"context":"`+test.context+`"}`))
+			request.Header.Set("Content-Type", "application/json")
+			request.AddCookie(&http.Cookie{Name: "sessionToken", Value: sessionToken})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+
+			if w.Code != test.wantStatus {
+				t.Fatalf("got %d: %s", w.Code, w.Body.String())
+			}
+			session, _ := getSession(sessionToken)
+			if session.Context != test.wantContext {
+				t.Errorf("session is in context %s, want %s", session.Context, test.wantContext)
+			}
+			if test.wantStatus == http.StatusOK {
+				if session.Username != "jane" {
+					t.Errorf("session user is %s, want jane", session.Username)
+				}
+				if !strings.Contains(session.KubeconfigContent, "added") {
+					t.Errorf("the switch overwrote a kubeconfig added meanwhile")
+				}
+			}
+		})
+	}
+}
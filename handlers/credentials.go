@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// credentialWarningDaysEnv is how many days ahead of their expiry users are
+// warned that their credentials expire
+const credentialWarningDaysEnv = "CREDENTIAL_EXPIRY_WARNING_DAYS"
+
+const defaultCredentialWarningDays = 7
+
+// credentialsExpiry returns when the credentials of contextName in the
+// kubeconfig stop working: the client certificate's NotAfter or the bearer
+// token's exp claim, whichever is sooner. ok is false when neither says.
+func credentialsExpiry(kubeconfigContent, contextName string) (expiry time.Time, ok bool) {
+	restConfig, err := kubeRestConfig(kubeconfigContent, contextName)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if cert := clientCertificate(restConfig); cert != nil {
+		expiry, ok = cert.NotAfter, true
+	}
+	if token := bearerToken(restConfig); token != "" {
+		// Opaque tokens have no claims and simply do not expire as far as we know
+		if _, claims, _, err := parseJWT(token); err == nil {
+			if exp, isNumber := claims["exp"].(float64); isNumber {
+				tokenExpiry := time.Unix(int64(exp), 0)
+				if !ok || tokenExpiry.Before(expiry) {
+					expiry, ok = tokenExpiry, true
+				}
+			}
+		}
+	}
+	return expiry, ok
+}
+
+// capSessionExpiry ends the session no later than the credentials of its
+// context, so it expires cleanly instead of failing mid-session, and renews
+// it. It reports an error when the credentials have already expired. OIDC
+// sessions refresh their tokens instead.
+func capSessionExpiry(session *SessionData) error {
+	session.CredentialsExpireAt = nil
+	if session.OIDC == nil {
+		if expiry, ok := credentialsExpiry(session.KubeconfigContent, session.Context); ok {
+			if time.Now().After(expiry) {
+				return fmt.Errorf("the credentials expired on %s", expiry.Format(time.RFC3339))
+			}
+			session.CredentialsExpireAt = &expiry
+		}
+	}
+	renewSession(session)
+	return nil
+}
+
+// credentialWarning is the warning shown when the session's credentials
+// expire within the warning period, or "" when they do not
+func credentialWarning(session SessionData) string {
+	if session.CredentialsExpireAt == nil {
+		return ""
+	}
+	expiry := *session.CredentialsExpireAt
+	if time.Until(expiry) > time.Duration(credentialWarningDays())*24*time.Hour {
+		return ""
+	}
+	return fmt.Sprintf("Your credentials for %s expire on %s, please get new ones before then", session.Context, expiry.Format(time.RFC1123))
+}
+
+func credentialWarningDays() int64 {
+	days, err := strconv.ParseInt(os.Getenv(credentialWarningDaysEnv), 10, 64)
+	if err != nil || days < 0 {
+		return defaultCredentialWarningDays
+	}
+	return days
+}
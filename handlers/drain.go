@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultDrainTimeout = 5 * time.Minute
+	// evictionRetryInterval is how long to wait before retrying an eviction
+	// refused because of a PodDisruptionBudget
+	evictionRetryInterval = 5 * time.Second
+	// drainJobRetention is how long finished drain jobs can still be polled
+	drainJobRetention = 1 * time.Hour
+)
+
+// podDeletionPollInterval is how often evicted pods are checked for being gone
+var podDeletionPollInterval = time.Second
+
+const (
+	DrainRunning   = "Running"
+	DrainSucceeded = "Succeeded"
+	DrainFailed    = "Failed"
+)
+
+// DrainOptions mirrors the kubectl drain flags the dashboard supports
+type DrainOptions struct {
+	DeleteEmptyDirData bool   `json:"deleteEmptyDirData"`
+	Force              bool   `json:"force"`
+	TimeoutSeconds     int    `json:"timeoutSeconds"`
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds,omitempty"`
+}
+
+// DrainJob is the progress of an asynchronous node drain. Pods are listed as
+// namespace/name. Terminating pods have been evicted but are still shutting
+// down; they move to Evicted once they are gone.
+type DrainJob struct {
+	ID           string     `json:"id"`
+	Node         string     `json:"node"`
+	Status       string     `json:"status"`
+	Evicted      []string   `json:"evicted"`
+	Terminating  []string   `json:"terminating"`
+	Pending      []string   `json:"pending"`
+	BlockedByPDB []string   `json:"blockedByPDB"`
+	Skipped      []string   `json:"skipped"`
+	Error        string     `json:"error,omitempty"`
+	StartedAt    time.Time  `json:"startedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+
+	sessionToken string
+}
+
+var (
+	drainJobs   = make(map[string]*DrainJob)
+	drainJobsMu sync.Mutex
+)
+
+func CordonNode(c *gin.Context) {
+	setNodeUnschedulable(c, true)
+}
+
+func UncordonNode(c *gin.Context) {
+	setNodeUnschedulable(c, false)
+}
+
+func setNodeUnschedulable(c *gin.Context, unschedulable bool) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+
+	if !requirePermission(c, clientset, sessionToken, metav1.NamespaceAll, "nodes.cordon") {
+		return
+	}
+
+	if err := patchNodeUnschedulable(clientset, c.Param("name"), unschedulable); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func patchNodeUnschedulable(clientset *kubernetes.Clientset, nodeName string, unschedulable bool) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable))
+	_, err := clientset.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// DrainNode cordons a node and evicts its pods in the background. It responds
+// with the job ID to poll with GetDrainJob or follow with StreamDrainJob.
+func DrainNode(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+
+	if !requireJobPermission(c, clientset, sessionToken, metav1.NamespaceAll, "nodes.cordon") ||
+		!requireJobPermission(c, clientset, sessionToken, metav1.NamespaceAll, "nodes.drain") {
+		return
+	}
+
+	var opts DrainOptions
+	if err := c.ShouldBindJSON(&opts); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drain options: " + err.Error()})
+		return
+	}
+	if opts.TimeoutSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drain options: timeoutSeconds must not be negative"})
+		return
+	}
+
+	job := &DrainJob{
+		ID:           newJobID(),
+		Node:         c.Param("name"),
+		Status:       DrainRunning,
+		Evicted:      []string{},
+		Terminating:  []string{},
+		Pending:      []string{},
+		BlockedByPDB: []string{},
+		Skipped:      []string{},
+		StartedAt:    time.Now(),
+		sessionToken: sessionToken,
+	}
+	drainJobsMu.Lock()
+	drainJobs[job.ID] = job
+	drainJobsMu.Unlock()
+
+	go runDrain(clientset, job, opts)
+
+	c.JSON(http.StatusAccepted, gin.H{"id": job.ID})
+}
+
+// GetDrainJob returns the current progress of a drain job
+func GetDrainJob(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	job, ok := drainJobSnapshot(c.Param("id"), sessionToken)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Drain job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// StreamDrainJob sends the progress of a drain job as server-sent events
+// until it finishes
+func StreamDrainJob(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	jobID := c.Param("id")
+	if _, ok := drainJobSnapshot(jobID, sessionToken); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Drain job not found"})
+		return
+	}
+
+	c.Stream(func(w io.Writer) bool {
+		job, ok := drainJobSnapshot(jobID, sessionToken)
+		if !ok {
+			return false
+		}
+		c.SSEvent("progress", job)
+		if job.Status != DrainRunning {
+			return false
+		}
+		time.Sleep(time.Second)
+		return true
+	})
+}
+
+// drainJobSnapshot returns a copy of a job, only to the session that started it
+func drainJobSnapshot(id, sessionToken string) (DrainJob, bool) {
+	drainJobsMu.Lock()
+	defer drainJobsMu.Unlock()
+	job, ok := drainJobs[id]
+	if !ok || job.sessionToken != sessionToken {
+		return DrainJob{}, false
+	}
+	snapshot := *job
+	snapshot.Evicted = append([]string{}, job.Evicted...)
+	snapshot.Terminating = append([]string{}, job.Terminating...)
+	snapshot.Pending = append([]string{}, job.Pending...)
+	snapshot.BlockedByPDB = append([]string{}, job.BlockedByPDB...)
+	snapshot.Skipped = append([]string{}, job.Skipped...)
+	return snapshot, true
+}
+
+func updateDrainJob(job *DrainJob, update func(job *DrainJob)) {
+	drainJobsMu.Lock()
+	defer drainJobsMu.Unlock()
+	update(job)
+}
+
+func finishDrainJob(job *DrainJob, err error) {
+	updateDrainJob(job, func(job *DrainJob) {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.Status = DrainSucceeded
+		if err != nil {
+			job.Status = DrainFailed
+			job.Error = err.Error()
+		}
+	})
+}
+
+// runDrain does what `kubectl drain` does: cordon the node, skip DaemonSet and
+// mirror pods, then evict the rest, retrying while a PodDisruptionBudget
+// blocks an eviction, and wait for the evicted pods to be deleted. All of it
+// has to finish before the timeout expires.
+func runDrain(clientset *kubernetes.Clientset, job *DrainJob, opts DrainOptions) {
+	timeout := defaultDrainTimeout
+	if opts.TimeoutSeconds > 0 {
+		timeout = time.Duration(opts.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := patchNodeUnschedulable(clientset, job.Node, true); err != nil {
+		finishDrainJob(job, fmt.Errorf("failed to cordon node: %v", err))
+		return
+	}
+
+	podList, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", job.Node).String(),
+	})
+	if err != nil {
+		finishDrainJob(job, fmt.Errorf("failed to list pods: %v", err))
+		return
+	}
+
+	var toEvict []corev1.Pod
+	var refused []string
+	for _, pod := range podList.Items {
+		key := pod.Namespace + "/" + pod.Name
+		switch reason := drainSkipReason(pod, opts); reason {
+		case "":
+			toEvict = append(toEvict, pod)
+		case "skip":
+			updateDrainJob(job, func(job *DrainJob) { job.Skipped = append(job.Skipped, key) })
+		default:
+			refused = append(refused, fmt.Sprintf("%s (%s)", key, reason))
+		}
+	}
+	if len(refused) > 0 {
+		finishDrainJob(job, fmt.Errorf("cannot drain node: %v", refused))
+		return
+	}
+
+	updateDrainJob(job, func(job *DrainJob) {
+		for _, pod := range toEvict {
+			job.Pending = append(job.Pending, pod.Namespace+"/"+pod.Name)
+		}
+	})
+
+	var terminating []corev1.Pod
+	for len(toEvict) > 0 {
+		var blocked []corev1.Pod
+		for _, pod := range toEvict {
+			key := pod.Namespace + "/" + pod.Name
+			eviction := &policyv1.Eviction{
+				ObjectMeta:    metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+				DeleteOptions: &metav1.DeleteOptions{GracePeriodSeconds: opts.GracePeriodSeconds},
+			}
+			err := clientset.CoreV1().Pods(pod.Namespace).EvictV1(ctx, eviction)
+			switch {
+			case err == nil:
+				terminating = append(terminating, pod)
+				updateDrainJob(job, func(job *DrainJob) {
+					job.Pending = removeString(job.Pending, key)
+					job.BlockedByPDB = removeString(job.BlockedByPDB, key)
+					job.Terminating = append(job.Terminating, key)
+				})
+			case apierrors.IsNotFound(err):
+				updateDrainJob(job, func(job *DrainJob) {
+					job.Pending = removeString(job.Pending, key)
+					job.BlockedByPDB = removeString(job.BlockedByPDB, key)
+					job.Evicted = append(job.Evicted, key)
+				})
+			case apierrors.IsTooManyRequests(err):
+				// The eviction would violate a PodDisruptionBudget; try again later
+				blocked = append(blocked, pod)
+				updateDrainJob(job, func(job *DrainJob) {
+					if !containsString(job.BlockedByPDB, key) {
+						job.BlockedByPDB = append(job.BlockedByPDB, key)
+					}
+				})
+			default:
+				finishDrainJob(job, fmt.Errorf("failed to evict %s: %v", key, err))
+				return
+			}
+		}
+
+		toEvict = blocked
+		if len(toEvict) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			finishDrainJob(job, fmt.Errorf("timed out after %s waiting for %d pods blocked by PodDisruptionBudgets", timeout, len(toEvict)))
+			return
+		case <-time.After(evictionRetryInterval):
+		}
+	}
+
+	if err := waitForDeletion(ctx, clientset, job, terminating); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s %v", timeout, err)
+		}
+		finishDrainJob(job, err)
+		return
+	}
+	finishDrainJob(job, nil)
+}
+
+// waitForDeletion waits until the evicted pods are gone, like `kubectl drain`
+// does, or until ctx expires. A pod with the same name but another UID is a replacement, so the
+// evicted one is gone.
+func waitForDeletion(ctx context.Context, clientset *kubernetes.Clientset, job *DrainJob, pods []corev1.Pod) error {
+	for len(pods) > 0 {
+		var remaining []corev1.Pod
+		for _, pod := range pods {
+			key := pod.Namespace + "/" + pod.Name
+			current, err := clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+			switch {
+			case apierrors.IsNotFound(err) || err == nil && current.UID != pod.UID:
+				updateDrainJob(job, func(job *DrainJob) {
+					job.Terminating = removeString(job.Terminating, key)
+					job.Evicted = append(job.Evicted, key)
+				})
+			default:
+				// Errors are retried like a pod that is still there
+				remaining = append(remaining, pod)
+			}
+		}
+
+		pods = remaining
+		if len(pods) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %d evicted pods to terminate", len(pods))
+		case <-time.After(podDeletionPollInterval):
+		}
+	}
+	return nil
+}
+
+// drainSkipReason decides what happens to a pod when its node is drained: ""
+// to evict it, "skip" to leave it alone, or why the drain must be refused.
+func drainSkipReason(pod corev1.Pod, opts DrainOptions) string {
+	// Mirror pods are managed by the kubelet and cannot be evicted
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return "skip"
+	}
+
+	controller := metav1.GetControllerOf(&pod)
+	// DaemonSet pods would be recreated on the node straight away
+	if controller != nil && controller.Kind == "DaemonSet" {
+		return "skip"
+	}
+	// Finished pods hold no resources and can simply go
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return ""
+	}
+
+	if controller == nil && !opts.Force {
+		return "not managed by a controller, use force"
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil && !opts.DeleteEmptyDirData {
+			return "uses emptyDir, use deleteEmptyDirData"
+		}
+	}
+	return ""
+}
+
+// cleanupDrainJobs forgets drain jobs that finished more than
+// drainJobRetention ago
+func cleanupDrainJobs() {
+	drainJobsMu.Lock()
+	defer drainJobsMu.Unlock()
+	for id, job := range drainJobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > drainJobRetention {
+			delete(drainJobs, id)
+		}
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	result := list[:0]
+	for _, item := range list {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
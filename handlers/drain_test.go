@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// drainAPIServer serves a node with the given pods. Evicted pods are still
+// returned for terminateAfter gets and then disappear, or are replaced by a
+// pod of the same name when replace is set. A negative terminateAfter keeps
+// them around forever.
+func drainAPIServer(t *testing.T, pods []string, terminateAfter int, replace bool) *httptest.Server {
+	controller := true
+	podObject := func(name string, uid types.UID) corev1.Pod {
+		return corev1.Pod{
+			TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: uid, OwnerReferences: []metav1.OwnerReference{
+				{Kind: "ReplicaSet", Name: "web", APIVersion: appsv1.SchemeGroupVersion.String(), Controller: &controller},
+			}},
+			Spec:   corev1.PodSpec{NodeName: "node-1"},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+
+	var mu sync.Mutex
+	evicted := map[string]bool{}
+	gets := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		name := strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/default/pods/")
+		switch {
+		case r.Method == http.MethodPatch && r.URL.Path == "/api/v1/nodes/node-1":
+			json.NewEncoder(w).Encode(corev1.Node{TypeMeta: metav1.TypeMeta{Kind: "Node", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: "node-1"}})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/pods":
+			list := corev1.PodList{TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"}}
+			for _, pod := range pods {
+				list.Items = append(list.Items, podObject(pod, types.UID("uid-"+pod)))
+			}
+			json.NewEncoder(w).Encode(list)
+		case r.Method == http.MethodPost && strings.HasSuffix(name, "/eviction"):
+			evicted[strings.TrimSuffix(name, "/eviction")] = true
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusSuccess})
+		case r.Method == http.MethodGet && evicted[name]:
+			gets[name]++
+			switch {
+			case terminateAfter < 0 || gets[name] <= terminateAfter:
+				json.NewEncoder(w).Encode(podObject(name, types.UID("uid-"+name)))
+			case replace:
+				json.NewEncoder(w).Encode(podObject(name, types.UID("uid-"+name+"-new")))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+			}
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRunDrainWaitsForDeletion(t *testing.T) {
+	interval := podDeletionPollInterval
+	podDeletionPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { podDeletionPollInterval = interval })
+
+	tests := []struct {
+		name           string
+		terminateAfter int
+		replace        bool
+		wantStatus     string
+		wantEvicted    []string
+		wantError      string
+	}{
+		{name: "already gone", terminateAfter: 0, wantStatus: DrainSucceeded, wantEvicted: []string{"default/web-a", "default/web-b"}},
+		{name: "terminates after a while", terminateAfter: 3, wantStatus: DrainSucceeded, wantEvicted: []string{"default/web-a", "default/web-b"}},
+		{name: "replaced under the same name", terminateAfter: 1, replace: true, wantStatus: DrainSucceeded, wantEvicted: []string{"default/web-a", "default/web-b"}},
+		{name: "never terminates", terminateAfter: -1, wantStatus: DrainFailed, wantEvicted: []string{}, wantError: "waiting for 2 evicted pods to terminate"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := drainAPIServer(t, []string{"web-a", "web-b"}, test.terminateAfter, test.replace)
+			clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL, QPS: 1000, Burst: 1000})
+			if err != nil {
+				t.Fatal(err)
+			}
+			job := &DrainJob{Node: "node-1", Status: DrainRunning, Evicted: []string{}, Terminating: []string{}, Pending: []string{}, BlockedByPDB: []string{}, Skipped: []string{}}
+
+			runDrain(clientset, job, DrainOptions{TimeoutSeconds: 1})
+
+			if job.Status != test.wantStatus || !strings.Contains(job.Error, test.wantError) {
+				t.Fatalf("got %s %q, want %s %q", job.Status, job.Error, test.wantStatus, test.wantError)
+			}
+			sort.Strings(job.Evicted)
+			if !reflect.DeepEqual(job.Evicted, test.wantEvicted) {
+				t.Errorf("evicted %v, want %v", job.Evicted, test.wantEvicted)
+			}
+			if test.wantStatus == DrainSucceeded && len(job.Terminating) != 0 {
+				t.Errorf("succeeded with %v still terminating", job.Terminating)
+			}
+		})
+	}
+}
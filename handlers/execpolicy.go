@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// execPolicyEnv decides what happens to kubeconfig users with exec credential
+// plugins, which would run a command on the dashboard server: "reject" (the
+// default) refuses the kubeconfig, "allowlist" only accepts the commands in
+// execAllowlistEnv and "strip" removes the plugins, leaving those users
+// without credentials. Allowlisted plugins may not set environment variables,
+// which could steer the command (LD_PRELOAD, AWS_CONFIG_FILE...); their
+// arguments are deliberately allowed, as the command runs without a shell.
+//
+// Users reading their token, client certificate or key from a file are
+// treated the same way whatever the policy, since the files would be read on
+// the dashboard server and sent to whichever server the kubeconfig names.
+// Uploaded clusters may not read their certificate authority from a file
+// either; kubeconfigs the dashboard builds itself, like an OIDC session's,
+// are trusted with theirs.
+const (
+	execPolicyEnv    = "KUBECONFIG_EXEC_POLICY"
+	execAllowlistEnv = "KUBECONFIG_EXEC_ALLOWLIST"
+)
+
+const (
+	execPolicyReject    = "reject"
+	execPolicyAllowlist = "allowlist"
+	execPolicyStrip     = "strip"
+)
+
+// builtinAuthProviders are the auth-provider plugins compiled into the
+// dashboard. They run no commands; any other provider cannot work anyway.
+var builtinAuthProviders = []string{"oidc"}
+
+func execPolicy() string {
+	if policy := strings.TrimSpace(os.Getenv(execPolicyEnv)); policy != "" {
+		return policy
+	}
+	return execPolicyReject
+}
+
+// checkExecPolicy reports an unknown policy at startup
+func checkExecPolicy() error {
+	switch execPolicy() {
+	case execPolicyReject, execPolicyAllowlist, execPolicyStrip:
+		return nil
+	}
+	return fmt.Errorf("invalid %s %q: must be %s, %s or %s", execPolicyEnv, execPolicy(), execPolicyReject, execPolicyAllowlist, execPolicyStrip)
+}
+
+// applyExecPolicy enforces the exec policy on every user of the kubeconfig,
+// not only the current context's, since the session can switch to any of
+// them. With the strip policy the plugins and credential files are removed in
+// place and the affected users returned.
+func applyExecPolicy(kubeconfig *clientcmdapi.Config) ([]string, error) {
+	policy := execPolicy()
+	var stripped []string
+	users := make([]string, 0, len(kubeconfig.AuthInfos))
+	for name := range kubeconfig.AuthInfos {
+		users = append(users, name)
+	}
+	sort.Strings(users)
+
+	for _, name := range users {
+		authInfo := kubeconfig.AuthInfos[name]
+		var problem string
+		switch {
+		case authInfo.Exec != nil && !(policy == execPolicyAllowlist && execAllowed(authInfo.Exec.Command)):
+			problem = fmt.Sprintf("user %q uses the exec credential plugin %q, which this dashboard does not run", name, authInfo.Exec.Command)
+		case authInfo.Exec != nil && len(authInfo.Exec.Env) > 0:
+			problem = fmt.Sprintf("user %q sets environment variables for the exec credential plugin %q, which this dashboard does not allow", name, authInfo.Exec.Command)
+		case authInfo.AuthProvider != nil && !containsString(builtinAuthProviders, authInfo.AuthProvider.Name):
+			problem = fmt.Sprintf("user %q uses the unsupported auth provider %q", name, authInfo.AuthProvider.Name)
+		case authInfo.TokenFile != "" || authInfo.ClientCertificate != "" || authInfo.ClientKey != "":
+			problem = fmt.Sprintf("user %q reads its credentials from files, which this dashboard does not allow", name)
+		default:
+			continue
+		}
+
+		if policy != execPolicyStrip {
+			return nil, fmt.Errorf("%s (%s=%s); please use a kubeconfig with an embedded token or client certificate", problem, execPolicyEnv, policy)
+		}
+		authInfo.Exec = nil
+		authInfo.AuthProvider = nil
+		authInfo.TokenFile = ""
+		authInfo.ClientCertificate = ""
+		authInfo.ClientKey = ""
+		stripped = append(stripped, name)
+	}
+	return stripped, nil
+}
+
+// execPolicyContent applies the exec policy to an uploaded kubeconfig and
+// returns the content to keep in the session, with warnings for every user
+// whose plugin was stripped
+func execPolicyContent(kubeconfigContent string) (string, []string, error) {
+	kubeconfig, err := clientcmd.Load([]byte(kubeconfigContent))
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating client config: %v", err)
+	}
+	if err := checkClusterFiles(kubeconfig); err != nil {
+		return "", nil, err
+	}
+	stripped, err := applyExecPolicy(kubeconfig)
+	if err != nil || len(stripped) == 0 {
+		return kubeconfigContent, nil, err
+	}
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return "", nil, err
+	}
+	var warnings []string
+	for _, name := range stripped {
+		warnings = append(warnings, fmt.Sprintf("removed the credential plugin or files of user %q (%s=%s)", name, execPolicyEnv, execPolicyStrip))
+	}
+	return string(content), warnings, nil
+}
+
+// checkClusterFiles refuses clusters whose certificate authority is a path,
+// which would be read on the dashboard server. There is nothing to strip them
+// to, so they are refused whatever the policy.
+func checkClusterFiles(kubeconfig *clientcmdapi.Config) error {
+	names := make([]string, 0, len(kubeconfig.Clusters))
+	for name := range kubeconfig.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if kubeconfig.Clusters[name].CertificateAuthority != "" {
+			return fmt.Errorf("cluster %q reads its certificate authority from a file, which this dashboard does not allow; please embed it as certificate-authority-data", name)
+		}
+	}
+	return nil
+}
+
+// execAllowed reports whether command is allowlisted. Commands are compared
+// exactly, so allowing "aws" does not allow "/tmp/aws".
+func execAllowed(command string) bool {
+	for _, allowed := range strings.Split(os.Getenv(execAllowlistEnv), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && allowed == command {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestApplyExecPolicy(t *testing.T) {
+	exec := func(command string, env ...clientcmdapi.ExecEnvVar) *clientcmdapi.AuthInfo {
+		return &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: command, Args: []string{"token"}, Env: env}}
+	}
+	authProvider := func(name string) *clientcmdapi.AuthInfo {
+		return &clientcmdapi.AuthInfo{AuthProvider: &clientcmdapi.AuthProviderConfig{Name: name}}
+	}
+
+	tests := []struct {
+		name         string
+		policy       string
+		allowlist    string
+		user         *clientcmdapi.AuthInfo
+		wantErr      string
+		wantStripped []string
+	}{
+		{name: "embedded token", policy: execPolicyReject, user: &clientcmdapi.AuthInfo{Token: "abc"}},
+		{name: "embedded certificate", policy: execPolicyReject, user: &clientcmdapi.AuthInfo{ClientCertificateData: []byte("cert"), ClientKeyData: []byte("key")}},
+		{name: "built-in auth provider", policy: execPolicyReject, user: authProvider("oidc")},
+		{name: "default policy rejects exec", user: exec("aws"), wantErr: `exec credential plugin "aws"`},
+		{name: "reject policy rejects exec", policy: execPolicyReject, allowlist: "aws", user: exec("aws"), wantErr: `exec credential plugin "aws"`},
+		{name: "allowlisted exec", policy: execPolicyAllowlist, allowlist: "kubelogin, aws", user: exec("aws")},
+		{name: "allowlist compares exactly", policy: execPolicyAllowlist, allowlist: "aws", user: exec("/tmp/aws"), wantErr: `"/tmp/aws"`},
+		{name: "allowlisted exec with environment", policy: execPolicyAllowlist, allowlist: "aws", user: exec("aws", clientcmdapi.ExecEnvVar{Name: "LD_PRELOAD", Value: "/tmp/evil.so"}), wantErr: "environment variables"},
+		{name: "unsupported auth provider", policy: execPolicyAllowlist, allowlist: "gcp", user: authProvider("gcp"), wantErr: `auth provider "gcp"`},
+		{name: "token file", policy: execPolicyReject, user: &clientcmdapi.AuthInfo{TokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token"}, wantErr: "from files"},
+		{name: "certificate files", policy: execPolicyAllowlist, user: &clientcmdapi.AuthInfo{ClientCertificate: "/etc/cert.pem", ClientKey: "/etc/key.pem"}, wantErr: "from files"},
+		{name: "strip removes exec", policy: execPolicyStrip, user: exec("aws"), wantStripped: []string{"user"}},
+		{name: "strip removes files", policy: execPolicyStrip, user: &clientcmdapi.AuthInfo{TokenFile: "/etc/token"}, wantStripped: []string{"user"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(execPolicyEnv, test.policy)
+			t.Setenv(execAllowlistEnv, test.allowlist)
+			kubeconfig := clientcmdapi.NewConfig()
+			kubeconfig.AuthInfos["user"] = test.user
+			kubeconfig.AuthInfos["other"] = &clientcmdapi.AuthInfo{Token: "abc"}
+
+			stripped, err := applyExecPolicy(kubeconfig)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(stripped, test.wantStripped) {
+				t.Errorf("stripped %v, want %v", stripped, test.wantStripped)
+			}
+			if len(stripped) > 0 {
+				user := kubeconfig.AuthInfos["user"]
+				if user.Exec != nil || user.AuthProvider != nil || user.TokenFile != "" || user.ClientCertificate != "" || user.ClientKey != "" {
+					t.Errorf("user still has a plugin or file: %+v", user)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckExecPolicy(t *testing.T) {
+	for policy, valid := range map[string]bool{"": true, execPolicyReject: true, execPolicyAllowlist: true, execPolicyStrip: true, "allow": false} {
+		t.Setenv(execPolicyEnv, policy)
+		if err := checkExecPolicy(); (err == nil) != valid {
+			t.Errorf("checkExecPolicy with %q: %v", policy, err)
+		}
+	}
+}
+
+func TestExecPolicyContentCertificateAuthority(t *testing.T) {
+	kubeconfig := func(cluster *clientcmdapi.Cluster) string {
+		config := clientcmdapi.NewConfig()
+		config.Clusters["prod"] = cluster
+		config.AuthInfos["admin"] = &clientcmdapi.AuthInfo{Token: "abc"}
+		config.Contexts["prod"] = &clientcmdapi.Context{Cluster: "prod", AuthInfo: "admin"}
+		content, err := clientcmd.Write(*config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	tests := []struct {
+		name    string
+		policy  string
+		cluster *clientcmdapi.Cluster
+		wantErr string
+	}{
+		{name: "embedded certificate authority", policy: execPolicyReject, cluster: &clientcmdapi.Cluster{Server: "https://prod", CertificateAuthorityData: []byte("ca")}},
+		{name: "certificate authority file", policy: execPolicyReject, cluster: &clientcmdapi.Cluster{Server: "https://prod", CertificateAuthority: "/etc/shadow"}, wantErr: `cluster "prod" reads its certificate authority from a file`},
+		{name: "certificate authority file with allowlist", policy: execPolicyAllowlist, cluster: &clientcmdapi.Cluster{Server: "https://prod", CertificateAuthority: "/etc/shadow"}, wantErr: "from a file"},
+		{name: "certificate authority file with strip", policy: execPolicyStrip, cluster: &clientcmdapi.Cluster{Server: "https://prod", CertificateAuthority: "/etc/shadow"}, wantErr: "from a file"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(execPolicyEnv, test.policy)
+			_, _, err := execPolicyContent(kubeconfig(test.cluster))
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCKubeconfigKeepsCertificateAuthorityFile(t *testing.T) {
+	t.Setenv(oidcClusterServerEnv, "https://kubernetes.example.com")
+	caFile := filepath.Join(t.TempDir(), "cluster-ca.crt")
+	if err := os.WriteFile(caFile, []byte("ca"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(oidcClusterCAFileEnv, caFile)
+	content, err := oidcKubeconfig(&oidcTokens{User: "oidc:jane", IDToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restConfig, err := kubeRestConfig(content, oidcContext)
+	if err != nil {
+		t.Fatalf("the OIDC kubeconfig was refused: %v", err)
+	}
+	if restConfig.CAFile != caFile {
+		t.Errorf("got CA file %q", restConfig.CAFile)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -16,7 +17,9 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 type PodResource struct {
@@ -28,6 +31,9 @@ type PodResource struct {
 	Age          string            `json:"age"`
 	Labels       map[string]string `json:"labels"`
 	ResourceType string            `json:"resourceType"`
+	Usage        *ResourceUsage    `json:"usage,omitempty"`
+
+	createdAt time.Time
 }
 
 type DeploymentResource struct {
@@ -37,7 +43,10 @@ type DeploymentResource struct {
 	UpToDate     string            `json:"up_to_date"`
 	Age          string            `json:"age"`
 	Labels       map[string]string `json:"labels"`
+	HPA          string            `json:"hpa,omitempty"`
 	ResourceType string            `json:"resourceType"`
+
+	createdAt time.Time
 }
 
 type StatefulSetResource struct {
@@ -46,31 +55,62 @@ type StatefulSetResource struct {
 	Ready        string            `json:"ready"`
 	Age          string            `json:"age"`
 	Labels       map[string]string `json:"labels"`
+	HPA          string            `json:"hpa,omitempty"`
 	ResourceType string            `json:"resourceType"`
+
+	createdAt time.Time
+}
+
+func (r PodResource) sortKey() sortKey {
+	key := sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt, restarts: r.Restarts}
+	if r.Usage != nil {
+		key.cpu = r.Usage.cpuMillis
+		key.memory = r.Usage.memoryBytes
+	}
+	return key
+}
+
+func (r DeploymentResource) sortKey() sortKey {
+	return sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt}
+}
+
+func (r StatefulSetResource) sortKey() sortKey {
+	return sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt}
 }
 
 // Add SessionData struct
 type SessionData struct {
 	KubeconfigContent string
-	Username          string
-	ExpiresAt         time.Time
+	// Context is the kubeconfig context the session is working in
+	Context string
+	// Username, Groups and UID are who the API server knows the user as.
+	// Groups are impersonated along with Username in in-cluster mode.
+	Username string
+	Groups   []string
+	UID      string
+	// OIDC holds the tokens of sessions started with an OIDC login
+	OIDC *oidcTokens
+	// CredentialsExpireAt is when the context's client certificate or token
+	// expires, if it says
+	CredentialsExpireAt *time.Time
+	// CreatedAt starts the absolute timeout, ExpiresAt slides with activity
+	CreatedAt time.Time
+	ExpiresAt time.Time
 }
 type bodyLogWriter struct {
 	gin.ResponseWriter
 	body *bytes.Buffer
 }
 
-var sessions = make(map[string]SessionData)
-
 func GetNamespaces(c *gin.Context) {
 	//log.Printf("Received request for GetNamespaces from %s", c.Request.RemoteAddr)
 	sessionToken, err := c.Cookie("sessionToken")
-	session, exists := sessions[sessionToken]
+	session, exists := getSession(sessionToken)
 	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
-	clientset, err := validateKubeConfig(sessions[sessionToken].KubeconfigContent)
+	clientset, err := sessionClientset(c, session)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
 		return
@@ -92,84 +132,112 @@ func GetNamespaces(c *gin.Context) {
 
 func GetDeployments(c *gin.Context) {
 	sessionToken, err := c.Cookie("sessionToken")
-	session, exists := sessions[sessionToken]
+	session, exists := getSession(sessionToken)
 	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	clientset, err := validateKubeConfig(sessions[sessionToken].KubeconfigContent)
+	clientset, err := sessionClientset(c, session)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
 		return
 	}
 
-	namespace := c.Param("namespace")
-	deployments, err := clientset.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		log.Printf("Response status: %d", http.StatusInternalServerError)
+	request, ok := parseListRequest(c, "age")
+	if !ok {
+		return
+	}
+	if c.Query("format") == "table" {
+		if err := checkTableSort(request.order); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		table, ok := getTableAcrossNamespaces(clientset, clientset.AppsV1().RESTClient(), "deployments", request, "Deployment")
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": joinNamespaceErrors(table.Errors)})
+			log.Printf("Response status: %d", http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, table)
 		return
 	}
 
-	var resourceList []DeploymentResource
-	for _, d := range deployments.Items {
-		totalReplicas := int(d.Status.Replicas)
-		if d.Spec.Replicas != nil {
-			totalReplicas = int(*d.Spec.Replicas) // Convert *int32 to int
+	respondList(c, clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[DeploymentResource], error) {
+		deployments, err := clientset.AppsV1().Deployments(namespace).List(context.TODO(), opts)
+		if err != nil {
+			return namespacePage[DeploymentResource]{}, err
+		}
+		page := namespacePage[DeploymentResource]{meta: deployments.ListMeta}
+		// Rows are still listed when autoscalers cannot be read
+		hpas, err := hpaTargets(clientset, namespace)
+		if err != nil {
+			page.warnings = append(page.warnings, "autoscalers unavailable: "+err.Error())
+		}
+		for _, d := range deployments.Items {
+			item := deploymentResource(d)
+			item.HPA = hpas[d.Namespace+"/Deployment/"+d.Name]
+			page.items = append(page.items, item)
 		}
+		return page, nil
+	})
+}
 
-		readyReplicas := int(d.Status.ReadyReplicas)             // Pods that are fully ready
-		availableReplicas := int(d.Status.AvailableReplicas)     // Pods that are running and available
-		updatedReplicas := int(d.Status.UpdatedReplicas)         // Pods that have been updated to the latest version
-		unavailableReplicas := int(d.Status.UnavailableReplicas) // Pods that are missing or failed
+// deploymentResource computes the dashboard's columns for a single Deployment
+func deploymentResource(d appsv1.Deployment) DeploymentResource {
+	totalReplicas := int(d.Status.Replicas)
+	if d.Spec.Replicas != nil {
+		totalReplicas = int(*d.Spec.Replicas) // Convert *int32 to int
+	}
 
-		// Default Status: ReadyReplicas / TotalReplicas
-		statusMessage := fmt.Sprintf("%d/%d", readyReplicas, totalReplicas)
+	readyReplicas := int(d.Status.ReadyReplicas)             // Pods that are fully ready
+	availableReplicas := int(d.Status.AvailableReplicas)     // Pods that are running and available
+	updatedReplicas := int(d.Status.UpdatedReplicas)         // Pods that have been updated to the latest version
+	unavailableReplicas := int(d.Status.UnavailableReplicas) // Pods that are missing or failed
 
-		// Check if rollout is in progress
-		isUpdating := false
-		for _, condition := range d.Status.Conditions {
-			if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionTrue {
-				isUpdating = true
-			}
-		}
+	// Default Status: ReadyReplicas / TotalReplicas
+	statusMessage := fmt.Sprintf("%d/%d", readyReplicas, totalReplicas)
 
-		// Show "Updating..." if rollout is ongoing
-		if isUpdating && (updatedReplicas < totalReplicas || availableReplicas < totalReplicas || unavailableReplicas > 0) {
-			statusMessage = fmt.Sprintf("%d/%d (Updating...)", availableReplicas, totalReplicas)
+	// Check if rollout is in progress
+	isUpdating := false
+	for _, condition := range d.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionTrue {
+			isUpdating = true
 		}
+	}
 
-		// Show "Unavailable..." if pods are missing
-		if !isUpdating && unavailableReplicas > 0 {
-			statusMessage = fmt.Sprintf("%d/%d (Unavailable...)", availableReplicas, totalReplicas)
-		}
+	// Show "Updating..." if rollout is ongoing
+	if isUpdating && (updatedReplicas < totalReplicas || availableReplicas < totalReplicas || unavailableReplicas > 0) {
+		statusMessage = fmt.Sprintf("%d/%d (Updating...)", availableReplicas, totalReplicas)
+	}
 
-		// Mark as failed if there is a replica failure
-		for _, condition := range d.Status.Conditions {
-			if condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == corev1.ConditionTrue {
-				statusMessage = fmt.Sprintf("%d/%d (Failed)", availableReplicas, totalReplicas)
-			}
-		}
+	// Show "Unavailable..." if pods are missing
+	if !isUpdating && unavailableReplicas > 0 {
+		statusMessage = fmt.Sprintf("%d/%d (Unavailable...)", availableReplicas, totalReplicas)
+	}
 
-		// Update the status message to reflect the correct ready replicas
-		if readyReplicas < totalReplicas {
-			statusMessage = fmt.Sprintf("%d/%d (Not Ready)", readyReplicas, totalReplicas)
+	// Mark as failed if there is a replica failure
+	for _, condition := range d.Status.Conditions {
+		if condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == corev1.ConditionTrue {
+			statusMessage = fmt.Sprintf("%d/%d (Failed)", availableReplicas, totalReplicas)
 		}
+	}
 
-		resource := DeploymentResource{
-			Name:         d.Name,
-			Namespace:    d.Namespace,
-			Ready:        statusMessage,
-			UpToDate:     fmt.Sprintf("%d", updatedReplicas),
-			Age:          formatDuration(time.Since(d.CreationTimestamp.Time)),
-			Labels:       d.Labels,
-			ResourceType: "Deployment",
-		}
-		resourceList = append(resourceList, resource)
+	// Update the status message to reflect the correct ready replicas
+	if readyReplicas < totalReplicas {
+		statusMessage = fmt.Sprintf("%d/%d (Not Ready)", readyReplicas, totalReplicas)
 	}
 
-	c.JSON(http.StatusOK, resourceList)
+	return DeploymentResource{
+		Name:         d.Name,
+		Namespace:    d.Namespace,
+		Ready:        statusMessage,
+		UpToDate:     fmt.Sprintf("%d", updatedReplicas),
+		Age:          formatDuration(time.Since(d.CreationTimestamp.Time)),
+		Labels:       d.Labels,
+		ResourceType: "Deployment",
+		createdAt:    d.CreationTimestamp.Time,
+	}
 }
 
 func RolloutRestart(c *gin.Context) {
@@ -180,39 +248,59 @@ func RolloutRestart(c *gin.Context) {
 		return
 	}
 	sessionToken, err := c.Cookie("sessionToken")
-	session, exists := sessions[sessionToken]
+	session, exists := getSession(sessionToken)
 	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
-	clientset, err := validateKubeConfig(sessions[sessionToken].KubeconfigContent)
+	clientset, err := sessionClientset(c, session)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
 		return
 	}
 	namespace := c.Param("namespace")
 	deploymentName := c.Param("name")
+	if !requirePermission(c, clientset, sessionToken, namespace, "deployments.restart") {
+		return
+	}
 
-	deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
-	if err != nil {
+	if err := restartDeployment(clientset, namespace, deploymentName); err != nil {
+		var quotaErr *quotaExceededError
+		if errors.As(err, &quotaErr) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		log.Printf("Response status: %d", http.StatusInternalServerError)
 		return
 	}
 
+	c.Status(http.StatusOK)
+}
+
+// restartDeployment does what `kubectl rollout restart` does: it changes the
+// pod template so the Deployment rolls out new pods. It first checks the
+// namespace's quotas leave room for the extra pods of the rollout.
+func restartDeployment(clientset *kubernetes.Clientset, namespace, deploymentName string) error {
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	surge, err := deploymentSurge(*deployment)
+	if err != nil {
+		return err
+	}
+	if err := checkQuotaHeadroom(clientset, namespace, deployment.Spec.Template, surge); err != nil {
+		return err
+	}
+
 	if deployment.Spec.Template.Annotations == nil {
 		deployment.Spec.Template.Annotations = make(map[string]string)
 	}
 	deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = metav1.Now().String()
 
 	_, err = clientset.AppsV1().Deployments(namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		log.Printf("Response status: %d", http.StatusInternalServerError)
-		return
-	}
-
-	c.Status(http.StatusOK)
+	return err
 }
 
 // Helper function to format duration
@@ -237,79 +325,107 @@ func formatDuration(d time.Duration) string {
 
 func GetStatefulSets(c *gin.Context) {
 	sessionToken, err := c.Cookie("sessionToken")
-	session, exists := sessions[sessionToken]
+	session, exists := getSession(sessionToken)
 	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	clientset, err := validateKubeConfig(sessions[sessionToken].KubeconfigContent)
+	clientset, err := sessionClientset(c, session)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
 		return
 	}
 
-	namespace := c.Param("namespace")
-	statefulSets, err := clientset.AppsV1().StatefulSets(namespace).List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		log.Printf("Response status: %d", http.StatusInternalServerError)
+	request, ok := parseListRequest(c, "age")
+	if !ok {
+		return
+	}
+	if c.Query("format") == "table" {
+		if err := checkTableSort(request.order); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		table, ok := getTableAcrossNamespaces(clientset, clientset.AppsV1().RESTClient(), "statefulsets", request, "StatefulSet")
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": joinNamespaceErrors(table.Errors)})
+			log.Printf("Response status: %d", http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, table)
 		return
 	}
 
-	var resourceList []StatefulSetResource
-	for _, ss := range statefulSets.Items {
-		totalReplicas := 0
-		if ss.Spec.Replicas != nil {
-			totalReplicas = int(*ss.Spec.Replicas)
+	respondList(c, clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[StatefulSetResource], error) {
+		statefulSets, err := clientset.AppsV1().StatefulSets(namespace).List(context.TODO(), opts)
+		if err != nil {
+			return namespacePage[StatefulSetResource]{}, err
+		}
+		page := namespacePage[StatefulSetResource]{meta: statefulSets.ListMeta}
+		// Rows are still listed when autoscalers cannot be read
+		hpas, err := hpaTargets(clientset, namespace)
+		if err != nil {
+			page.warnings = append(page.warnings, "autoscalers unavailable: "+err.Error())
 		}
+		for _, ss := range statefulSets.Items {
+			item := statefulSetResource(ss)
+			item.HPA = hpas[ss.Namespace+"/StatefulSet/"+ss.Name]
+			page.items = append(page.items, item)
+		}
+		return page, nil
+	})
+}
 
-		readyReplicas := int(ss.Status.ReadyReplicas) // Fully Ready Pods
-		//currentReplicas := int(ss.Status.CurrentReplicas)     // Currently running pods
-		updatedReplicas := int(ss.Status.UpdatedReplicas)     // Updated pods (new spec)
-		availableReplicas := int(ss.Status.AvailableReplicas) // Pods that are running and available
+// statefulSetResource computes the dashboard's columns for a single StatefulSet
+func statefulSetResource(ss appsv1.StatefulSet) StatefulSetResource {
+	totalReplicas := 0
+	if ss.Spec.Replicas != nil {
+		totalReplicas = int(*ss.Spec.Replicas)
+	}
 
-		// Default status: ReadyReplicas / TotalReplicas
-		statusMessage := fmt.Sprintf("%d/%d", readyReplicas, totalReplicas)
+	readyReplicas := int(ss.Status.ReadyReplicas) // Fully Ready Pods
+	//currentReplicas := int(ss.Status.CurrentReplicas)     // Currently running pods
+	updatedReplicas := int(ss.Status.UpdatedReplicas)     // Updated pods (new spec)
+	availableReplicas := int(ss.Status.AvailableReplicas) // Pods that are running and available
 
-		// Identify rollout status
-		isUpdating := false
-		for _, condition := range ss.Status.Conditions {
-			if condition.Type == appsv1.StatefulSetConditionType("Progressing") && condition.Status == corev1.ConditionTrue {
-				isUpdating = true
-			}
-		}
+	// Default status: ReadyReplicas / TotalReplicas
+	statusMessage := fmt.Sprintf("%d/%d", readyReplicas, totalReplicas)
 
-		// Show "Updating..." if rollout is ongoing
-		if isUpdating && (updatedReplicas < totalReplicas || availableReplicas < totalReplicas) {
-			statusMessage = fmt.Sprintf("%d/%d (Updating...)", availableReplicas, totalReplicas)
+	// Identify rollout status
+	isUpdating := false
+	for _, condition := range ss.Status.Conditions {
+		if condition.Type == appsv1.StatefulSetConditionType("Progressing") && condition.Status == corev1.ConditionTrue {
+			isUpdating = true
 		}
+	}
 
-		// Detect Unavailable pods
-		unavailableReplicas := totalReplicas - availableReplicas
-		if unavailableReplicas > 0 {
-			statusMessage = fmt.Sprintf("%d/%d (Unavailable...)", availableReplicas, totalReplicas)
-		}
+	// Show "Updating..." if rollout is ongoing
+	if isUpdating && (updatedReplicas < totalReplicas || availableReplicas < totalReplicas) {
+		statusMessage = fmt.Sprintf("%d/%d (Updating...)", availableReplicas, totalReplicas)
+	}
 
-		// Mark as failed if pods are stuck
-		for _, condition := range ss.Status.Conditions {
-			if condition.Type == "ReplicaFailure" && condition.Status == corev1.ConditionTrue {
-				statusMessage = fmt.Sprintf("%d/%d (Failed)", availableReplicas, totalReplicas)
-			}
-		}
+	// Detect Unavailable pods
+	unavailableReplicas := totalReplicas - availableReplicas
+	if unavailableReplicas > 0 {
+		statusMessage = fmt.Sprintf("%d/%d (Unavailable...)", availableReplicas, totalReplicas)
+	}
 
-		resource := StatefulSetResource{
-			Name:         ss.Name,
-			Namespace:    ss.Namespace,
-			Ready:        statusMessage,
-			Age:          formatDuration(time.Since(ss.CreationTimestamp.Time)),
-			Labels:       ss.Labels,
-			ResourceType: "StatefulSet",
+	// Mark as failed if pods are stuck
+	for _, condition := range ss.Status.Conditions {
+		if condition.Type == "ReplicaFailure" && condition.Status == corev1.ConditionTrue {
+			statusMessage = fmt.Sprintf("%d/%d (Failed)", availableReplicas, totalReplicas)
 		}
-		resourceList = append(resourceList, resource)
 	}
 
-	c.JSON(http.StatusOK, resourceList)
+	return StatefulSetResource{
+		Name:         ss.Name,
+		Namespace:    ss.Namespace,
+		Ready:        statusMessage,
+		Age:          formatDuration(time.Since(ss.CreationTimestamp.Time)),
+		Labels:       ss.Labels,
+		ResourceType: "StatefulSet",
+		createdAt:    ss.CreationTimestamp.Time,
+	}
 }
 
 func RolloutRestartStatefulSet(c *gin.Context) {
@@ -321,117 +437,172 @@ func RolloutRestartStatefulSet(c *gin.Context) {
 		return
 	}
 	sessionToken, err := c.Cookie("sessionToken")
-	session, exists := sessions[sessionToken]
+	session, exists := getSession(sessionToken)
 	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
-	clientset, err := validateKubeConfig(sessions[sessionToken].KubeconfigContent)
+	clientset, err := sessionClientset(c, session)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
 		return
 	}
 	namespace := c.Param("namespace")
 	statefulSetName := c.Param("name")
+	if !requirePermission(c, clientset, sessionToken, namespace, "statefulsets.restart") {
+		return
+	}
 
-	statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(context.TODO(), statefulSetName, metav1.GetOptions{})
-	if err != nil {
+	if err := restartStatefulSet(clientset, namespace, statefulSetName); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		log.Printf("Response status: %d", http.StatusInternalServerError)
 		return
 	}
 
+	c.Status(http.StatusOK)
+}
+
+// restartStatefulSet changes the pod template so the StatefulSet rolls its pods
+func restartStatefulSet(clientset *kubernetes.Clientset, namespace, statefulSetName string) error {
+	statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(context.TODO(), statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
 	if statefulSet.Spec.Template.Annotations == nil {
 		statefulSet.Spec.Template.Annotations = make(map[string]string)
 	}
 	statefulSet.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = metav1.Now().String()
 
 	_, err = clientset.AppsV1().StatefulSets(namespace).Update(context.TODO(), statefulSet, metav1.UpdateOptions{})
+	return err
+}
+
+// restartDaemonSet changes the pod template so the DaemonSet rolls its pods
+func restartDaemonSet(clientset *kubernetes.Clientset, namespace, daemonSetName string) error {
+	daemonSet, err := clientset.AppsV1().DaemonSets(namespace).Get(context.TODO(), daemonSetName, metav1.GetOptions{})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		log.Printf("Response status: %d", http.StatusInternalServerError)
-		return
+		return err
 	}
 
-	c.Status(http.StatusOK)
+	if daemonSet.Spec.Template.Annotations == nil {
+		daemonSet.Spec.Template.Annotations = make(map[string]string)
+	}
+	daemonSet.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = metav1.Now().String()
+
+	_, err = clientset.AppsV1().DaemonSets(namespace).Update(context.TODO(), daemonSet, metav1.UpdateOptions{})
+	return err
 }
 
 // GetPods fetches Pods in the specified namespace
 func GetPods(c *gin.Context) {
 	//log.Printf("Received request for GetPods from %s", c.Request.RemoteAddr)
 	sessionToken, err := c.Cookie("sessionToken")
-	session, exists := sessions[sessionToken]
+	session, exists := getSession(sessionToken)
 	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
-	clientset, err := validateKubeConfig(sessions[sessionToken].KubeconfigContent)
+	clientset, err := sessionClientset(c, session)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
 		return
 	}
-	namespace := c.Param("namespace")
-	podList, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		log.Printf("Response status: %d", http.StatusInternalServerError)
+	request, ok := parseListRequest(c, "age", "restarts", "cpu", "memory")
+	if !ok {
+		return
+	}
+	if c.Query("format") == "table" {
+		if err := checkTableSort(request.order); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		table, ok := getTableAcrossNamespaces(clientset, clientset.CoreV1().RESTClient(), "pods", request, "Pod")
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": joinNamespaceErrors(table.Errors)})
+			log.Printf("Response status: %d", http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, table)
 		return
 	}
 
-	var resourceList []PodResource
-	for _, pod := range podList.Items {
-		// Get the status of each of the pods
-		podStatus := pod.Status
-
-		var containerRestarts int32
-		var containerReady int
-		var totalContainers int
-		var containerReasonNotReady string
-
-		// If a pod has multiple containers, get the status from all
-		for i := range pod.Spec.Containers {
-			if !podStatus.ContainerStatuses[i].Ready {
-				if waiting := podStatus.ContainerStatuses[i].State.Waiting; waiting != nil {
-					containerReasonNotReady += waiting.Reason + " "
-				}
-				if terminated := podStatus.ContainerStatuses[i].State.Terminated; terminated != nil {
-					containerReasonNotReady += terminated.Reason + " "
-				}
+	respondList(c, clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[PodResource], error) {
+		podList, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), opts)
+		if err != nil {
+			return namespacePage[PodResource]{}, err
+		}
+		page := namespacePage[PodResource]{meta: podList.ListMeta}
+		// Usage is optional: without metrics-server the pods are still listed
+		usage, err := getPodMetrics(clientset, namespace, opts)
+		if err != nil {
+			page.warnings = append(page.warnings, err.Error())
+		}
+		for _, pod := range podList.Items {
+			item := podResource(pod)
+			if podMetrics, ok := usage[pod.Namespace+"/"+pod.Name]; ok {
+				item.Usage = podUsage(pod, podMetrics)
 			}
+			page.items = append(page.items, item)
+		}
+		return page, nil
+	})
+}
 
-			containerRestarts += podStatus.ContainerStatuses[i].RestartCount
-			if podStatus.ContainerStatuses[i].Ready {
-				containerReady++
+// podResource computes the dashboard's columns for a single Pod
+func podResource(pod corev1.Pod) PodResource {
+	// Get the status of each of the pods
+	podStatus := pod.Status
+
+	var containerRestarts int32
+	var containerReady int
+	var totalContainers int
+	var containerReasonNotReady string
+
+	// If a pod has multiple containers, get the status from all
+	for i := range pod.Spec.Containers {
+		totalContainers++
+		// Pending pods have no container statuses yet
+		if i >= len(podStatus.ContainerStatuses) {
+			continue
+		}
+		if !podStatus.ContainerStatuses[i].Ready {
+			if waiting := podStatus.ContainerStatuses[i].State.Waiting; waiting != nil {
+				containerReasonNotReady += waiting.Reason + " "
+			}
+			if terminated := podStatus.ContainerStatuses[i].State.Terminated; terminated != nil {
+				containerReasonNotReady += terminated.Reason + " "
 			}
-			totalContainers++
 		}
 
-		// Get the values from the pod status
-		name := pod.GetName()
-		ready := fmt.Sprintf("%v/%v", containerReady, totalContainers)
-
-		var actualStatus string
-		if len(containerReasonNotReady) > 0 {
-			actualStatus = strings.TrimSpace(containerReasonNotReady) // Trim any trailing spaces
-		} else {
-			actualStatus = string(podStatus.Phase)
+		containerRestarts += podStatus.ContainerStatuses[i].RestartCount
+		if podStatus.ContainerStatuses[i].Ready {
+			containerReady++
 		}
+	}
 
-		// Append this to the resource list
-		resource := PodResource{
-			Name:         name,
-			Namespace:    pod.Namespace,
-			Ready:        ready,
-			Status:       actualStatus,
-			Restarts:     containerRestarts,
-			Labels:       pod.Labels,
-			Age:          formatDuration(time.Since(pod.CreationTimestamp.Time)),
-			ResourceType: "Pod",
-		}
-		resourceList = append(resourceList, resource)
+	// Get the values from the pod status
+	name := pod.GetName()
+	ready := fmt.Sprintf("%v/%v", containerReady, totalContainers)
+
+	var actualStatus string
+	if len(containerReasonNotReady) > 0 {
+		actualStatus = strings.TrimSpace(containerReasonNotReady) // Trim any trailing spaces
+	} else {
+		actualStatus = string(podStatus.Phase)
 	}
 
-	c.JSON(http.StatusOK, resourceList)
+	return PodResource{
+		Name:         name,
+		Namespace:    pod.Namespace,
+		Ready:        ready,
+		Status:       actualStatus,
+		Restarts:     containerRestarts,
+		Labels:       pod.Labels,
+		Age:          formatDuration(time.Since(pod.CreationTimestamp.Time)),
+		ResourceType: "Pod",
+		createdAt:    pod.CreationTimestamp.Time,
+	}
 }
 
 // RolloutRestartPod restarts the specified Pod
@@ -444,19 +615,22 @@ func RolloutRestartPod(c *gin.Context) {
 		return
 	}
 	sessionToken, err := c.Cookie("sessionToken")
-	session, exists := sessions[sessionToken]
+	session, exists := getSession(sessionToken)
 	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	clientset, err := validateKubeConfig(sessions[sessionToken].KubeconfigContent)
+	clientset, err := sessionClientset(c, session)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
 		return
 	}
 	namespace := c.Param("namespace")
 	podName := c.Param("name")
+	if !requirePermission(c, clientset, sessionToken, namespace, "pods.delete") {
+		return
+	}
 
 	// Delete the Pod to trigger a restart
 	err = clientset.CoreV1().Pods(namespace).Delete(context.TODO(), podName, metav1.DeleteOptions{})
@@ -469,19 +643,48 @@ func RolloutRestartPod(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
-func validateKubeConfig(kubeconfigContent string) (*kubernetes.Clientset, error) {
-	clientConfig, err := clientcmd.NewClientConfigFromBytes([]byte(kubeconfigContent))
+// validateKubeConfig builds a client for contextName in the kubeconfig, or for
+// its current context when contextName is empty
+func validateKubeConfig(kubeconfigContent, contextName string) (*kubernetes.Clientset, error) {
+	restConfig, err := kubeRestConfig(kubeconfigContent, contextName)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// kubeRestConfig is the client configuration of contextName in the kubeconfig
+func kubeRestConfig(kubeconfigContent, contextName string) (*rest.Config, error) {
+	kubeconfig, err := clientcmd.Load([]byte(kubeconfigContent))
 	if err != nil {
 		return nil, fmt.Errorf("error creating client config: %v", err)
 	}
+	if _, err := applyExecPolicy(kubeconfig); err != nil {
+		return nil, err
+	}
+	clientConfig := clientcmd.NewNonInteractiveClientConfig(*kubeconfig, contextName, &clientcmd.ConfigOverrides{}, nil)
 	restConfig, err := clientConfig.ClientConfig()
 	if err != nil {
 		return nil, fmt.Errorf("error getting rest config: %v", err)
 	}
-	return kubernetes.NewForConfig(restConfig)
+	return restConfig, nil
+}
+
+// kubeconfigIdentity resolves who contextName of the kubeconfig authenticates
+// as, falling back to the name of its kubeconfig user
+func kubeconfigIdentity(clientset *kubernetes.Clientset, kubeconfigContent, contextName, authInfo string) userIdentity {
+	restConfig, err := kubeRestConfig(kubeconfigContent, contextName)
+	if err != nil {
+		return userIdentity{Username: authInfo}
+	}
+	return resolveIdentity(clientset, restConfig, authInfo)
 }
 
 func UploadKubeConfig(c *gin.Context) {
+	if authMode() == authModeInCluster {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Kubeconfig upload is disabled in in-cluster mode"})
+		return
+	}
 	file, err := c.FormFile("kubeconfig")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file"})
@@ -500,22 +703,25 @@ func UploadKubeConfig(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read kubeconfig content"})
 		return
 	}
-	kubeconfigContent := string(kubeconfigBytes)
+	// Credential plugins would run commands on this server
+	kubeconfigContent, warnings, err := execPolicyContent(string(kubeconfigBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid kubeconfig: " + err.Error()})
+		return
+	}
 
-	clientset, err := validateKubeConfig(kubeconfigContent)
+	clientset, err := validateKubeConfig(kubeconfigContent, "")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid kubeconfig: " + err.Error()})
 		return
 	}
 
-	// Extract username from kubeconfig
-	kubeconfig, err := clientcmd.Load(kubeconfigBytes)
+	kubeconfig, err := clientcmd.Load([]byte(kubeconfigContent))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load kubeconfig: " + err.Error()})
 		return
 	}
 	currentContext := kubeconfig.Contexts[kubeconfig.CurrentContext]
-	username := currentContext.AuthInfo
 
 	// Example interaction: List namespaces
 	namespaces, err := clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
@@ -523,43 +729,124 @@ func UploadKubeConfig(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to interact with cluster: " + err.Error()})
 		return
 	}
+	// append=true adds the kubeconfig's contexts to the current session
+	// instead of replacing it, so one session can reach several clusters
+	if c.PostForm("append") == "true" {
+		appendKubeConfig(c, kubeconfig, warnings)
+		return
+	}
+	// The kubeconfig user's name is only a local label, so ask who it is
+	identity := kubeconfigIdentity(clientset, kubeconfigContent, "", currentContext.AuthInfo)
 	// Invalidate any existing session for this client before creating a new one
 	if existingToken, err := c.Cookie("sessionToken"); err == nil {
 		// Delete old session if it exists
-		delete(sessions, existingToken)
+		deleteSession(existingToken)
+		forgetPermissions(existingToken)
 	}
 	// Create a session token and store the kubeconfig content
-	sessionToken := fmt.Sprintf("%d", time.Now().UnixNano())
-	sessions[sessionToken] = SessionData{
+	sessionToken := newSessionToken()
+	session := SessionData{
 		KubeconfigContent: kubeconfigContent,
-		Username:          username,
-		ExpiresAt:         time.Now().Add(1 * time.Hour),
+		Context:           kubeconfig.CurrentContext,
+		Username:          identity.Username,
+		Groups:            identity.Groups,
+		UID:               identity.UID,
+		CreatedAt:         time.Now(),
 	}
+	if err := capSessionExpiry(&session); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid kubeconfig: " + err.Error()})
+		return
+	}
+	if warning := credentialWarning(session); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	saveSession(sessionToken, session)
 	// Set the session token in a cookie
-	c.SetCookie("sessionToken", sessionToken, 3600, "/", "", false, true)
+	c.SetCookie("sessionToken", sessionToken, sessionCookieMaxAge(session), "/", "", false, true)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "Kubeconfig validated successfully",
 		"namespaces": namespaces,
-		"user":       username,
+		"user":       identity.Username,
+		"groups":     identity.Groups,
+		"context":    kubeconfig.CurrentContext,
+		"warnings":   warnings,
+	})
+}
+
+// appendKubeConfig merges kubeconfig into the session's kubeconfig, keeping
+// the active context
+func appendKubeConfig(c *gin.Context, kubeconfig *clientcmdapi.Config, warnings []string) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	var added []string
+	// Merge into the current session, which renewals and token refreshes may
+	// have changed since it was read
+	session, exists, err = updateSession(sessionToken, func(session *SessionData) error {
+		merged, err := clientcmd.Load([]byte(session.KubeconfigContent))
+		if err != nil {
+			return fmt.Errorf("failed to load kubeconfig: %v", err)
+		}
+		added = mergeKubeconfig(merged, kubeconfig)
+		mergedBytes, err := clientcmd.Write(*merged)
+		if err != nil {
+			return fmt.Errorf("failed to merge kubeconfig: %v", err)
+		}
+		session.KubeconfigContent = string(mergedBytes)
+		return capSessionExpiry(session)
+	})
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid kubeconfig: " + err.Error()})
+		return
+	}
+	// Permissions were answered for the previous credentials
+	forgetPermissions(sessionToken)
+	c.SetCookie("sessionToken", sessionToken, sessionCookieMaxAge(session), "/", "", false, true)
+	if warning := credentialWarning(session); warning != "" {
+		warnings = append(warnings, warning)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Kubeconfig added to the session",
+		"user":     session.Username,
+		"context":  session.Context,
+		"contexts": added,
+		"warnings": warnings,
 	})
 }
 
 func AuthCheck(c *gin.Context) {
 	//log.Printf("Received request for Authentication check from %s", c.Request.RemoteAddr)
 	sessionToken, err := c.Cookie("sessionToken")
-	session, exists := sessions[sessionToken]
+	session, exists := getSession(sessionToken)
 	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"user": sessions[sessionToken].Username})
+	// The remaining lifetime lets the frontend warn before the session ends
+	response := sessionLifetime(session)
+	response["user"] = session.Username
+	response["groups"] = session.Groups
+	response["uid"] = session.UID
+	response["context"] = session.Context
+	response["credentialsExpireAt"] = session.CredentialsExpireAt
+	response["credentialWarning"] = credentialWarning(session)
+	c.JSON(http.StatusOK, response)
 }
 
 // Add this function to handle logout
 func Logout(c *gin.Context) {
 	sessionToken, _ := c.Cookie("sessionToken")
-	delete(sessions, sessionToken)
+	deleteSession(sessionToken)
+	forgetPermissions(sessionToken)
 	c.SetCookie("sessionToken", "", -1, "/", "", false, true)
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
@@ -591,17 +878,20 @@ func LoggingMiddleware() gin.HandlerFunc {
 		// Capture response details
 		status := c.Writer.Status()
 		username := "Unauthorized"
+		kubeContext := "-"
 		if sessionToken, err := c.Cookie("sessionToken"); err == nil {
-			if session, exists := sessions[sessionToken]; exists {
+			if session, exists := getSession(sessionToken); exists {
 				username = session.Username
+				kubeContext = session.Context
 			}
 		}
 
 		// Format log entry
 		logEntry := fmt.Sprintf(
-			"[%s] %s %s %s %d %s\n",
+			"[%s] %s %s %s %s %d %s\n",
 			start.Format(time.RFC3339),
 			username,
+			kubeContext,
 			clientIP,
 			fmt.Sprintf("%s %s", method, path),
 			status,
@@ -627,10 +917,11 @@ func LoggingMiddleware() gin.HandlerFunc {
 func CleanupSessions() {
 	for {
 		time.Sleep(5 * time.Minute) // Runs every 5 minutes
-		for token, session := range sessions {
-			if time.Now().After(session.ExpiresAt) {
-				delete(sessions, token)
-			}
+		for _, token := range expireSessions() {
+			forgetPermissions(token)
 		}
+		cleanupDrainJobs()
+		cleanupRestartJobs()
+		cleanupOIDCLogins()
 	}
 }
@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// HPAMetric is one metric an HPA scales on, with its target and the value
+// the autoscaler last observed
+type HPAMetric struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Target  string `json:"target"`
+	Current string `json:"current"`
+}
+
+type HPACondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type HPAResource struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	TargetKind      string            `json:"targetKind"`
+	TargetName      string            `json:"targetName"`
+	MinReplicas     int32             `json:"minReplicas"`
+	MaxReplicas     int32             `json:"maxReplicas"`
+	CurrentReplicas int32             `json:"currentReplicas"`
+	DesiredReplicas int32             `json:"desiredReplicas"`
+	Metrics         []HPAMetric       `json:"metrics"`
+	Conditions      []HPACondition    `json:"conditions"`
+	Age             string            `json:"age"`
+	Labels          map[string]string `json:"labels"`
+	ResourceType    string            `json:"resourceType"`
+
+	createdAt time.Time
+}
+
+func (r HPAResource) sortKey() sortKey {
+	return sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt}
+}
+
+// HPAReplicas is the body of an HPA edit. Fields left out keep their value.
+type HPAReplicas struct {
+	MinReplicas *int32 `json:"minReplicas"`
+	MaxReplicas *int32 `json:"maxReplicas"`
+}
+
+// GetHPAs lists HorizontalPodAutoscalers with their targets, replica bounds,
+// metrics and conditions
+func GetHPAs(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	request, ok := parseListRequest(c, "age")
+	if !ok {
+		return
+	}
+
+	respondList(c, clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[HPAResource], error) {
+		hpas, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(context.TODO(), opts)
+		if err != nil {
+			return namespacePage[HPAResource]{}, err
+		}
+		page := namespacePage[HPAResource]{meta: hpas.ListMeta}
+		for _, hpa := range hpas.Items {
+			page.items = append(page.items, hpaResource(hpa))
+		}
+		return page, nil
+	})
+}
+
+func GetHPA(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+
+	hpa, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(c.Param("namespace")).Get(context.TODO(), c.Param("name"), metav1.GetOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, hpaResource(*hpa))
+}
+
+// UpdateHPAReplicas changes an HPA's min and max replicas. With ?dryRun=true
+// the API server validates the change without persisting it.
+func UpdateHPAReplicas(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+
+	var replicas HPAReplicas
+	if err := c.ShouldBindJSON(&replicas); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid replicas: " + err.Error()})
+		return
+	}
+	namespace := c.Param("namespace")
+	hpaName := c.Param("name")
+	if !requirePermission(c, clientset, sessionToken, namespace, "horizontalpodautoscalers.update") {
+		return
+	}
+
+	hpa, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).Get(context.TODO(), hpaName, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+	if replicas.MinReplicas != nil {
+		hpa.Spec.MinReplicas = replicas.MinReplicas
+	}
+	if replicas.MaxReplicas != nil {
+		hpa.Spec.MaxReplicas = *replicas.MaxReplicas
+	}
+	minReplicas := int32(1)
+	if hpa.Spec.MinReplicas != nil {
+		minReplicas = *hpa.Spec.MinReplicas
+	}
+	if minReplicas < 1 || hpa.Spec.MaxReplicas < minReplicas {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid replicas: need 1 <= minReplicas (%d) <= maxReplicas (%d)", minReplicas, hpa.Spec.MaxReplicas)})
+		return
+	}
+
+	if err := checkHPAHeadroom(clientset, hpa, minReplicas); err != nil {
+		var quotaErr *quotaExceededError
+		if errors.As(err, &quotaErr) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	updateOptions := metav1.UpdateOptions{}
+	dryRun := c.Query("dryRun") == "true"
+	if dryRun {
+		updateOptions.DryRun = []string{metav1.DryRunAll}
+	}
+	updated, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).Update(context.TODO(), hpa, updateOptions)
+	if apierrors.IsInvalid(err) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	if !dryRun {
+		log.Printf("HPA %s/%s replicas set to %d-%d", namespace, hpaName, minReplicas, hpa.Spec.MaxReplicas)
+	}
+	c.JSON(http.StatusOK, gin.H{"dryRun": dryRun, "hpa": hpaResource(*updated)})
+}
+
+// checkHPAHeadroom checks the quota has room for the pods a Deployment or
+// StatefulSet gains when minReplicas rises above its current replicas
+func checkHPAHeadroom(clientset *kubernetes.Clientset, hpa *autoscalingv2.HorizontalPodAutoscaler, minReplicas int32) error {
+	target := hpa.Spec.ScaleTargetRef
+	var template corev1.PodTemplateSpec
+	var current int32
+	switch target.Kind {
+	case "Deployment":
+		deployment, err := clientset.AppsV1().Deployments(hpa.Namespace).Get(context.TODO(), target.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		template, current = deployment.Spec.Template, deployment.Status.Replicas
+	case "StatefulSet":
+		statefulSet, err := clientset.AppsV1().StatefulSets(hpa.Namespace).Get(context.TODO(), target.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		template, current = statefulSet.Spec.Template, statefulSet.Status.Replicas
+	default:
+		return nil
+	}
+	return checkQuotaHeadroom(clientset, hpa.Namespace, template, int64(minReplicas-current))
+}
+
+// hpaTargets maps "namespace/Kind/name" of every HPA target in namespace to
+// the HPA scaling it, so workload rows can link to their autoscaler
+func hpaTargets(clientset *kubernetes.Clientset, namespace string) (map[string]string, error) {
+	hpas, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(hpas.Items))
+	for _, hpa := range hpas.Items {
+		targets[hpa.Namespace+"/"+hpa.Spec.ScaleTargetRef.Kind+"/"+hpa.Spec.ScaleTargetRef.Name] = hpa.Name
+	}
+	return targets, nil
+}
+
+// hpaResource computes the dashboard's columns for a single HPA
+func hpaResource(hpa autoscalingv2.HorizontalPodAutoscaler) HPAResource {
+	minReplicas := int32(1)
+	if hpa.Spec.MinReplicas != nil {
+		minReplicas = *hpa.Spec.MinReplicas
+	}
+
+	current := make(map[string]string)
+	for _, status := range hpa.Status.CurrentMetrics {
+		metric := hpaMetricCurrent(status)
+		current[metric.Type+"/"+metric.Name] = metric.Current
+	}
+	metrics := []HPAMetric{}
+	for _, spec := range hpa.Spec.Metrics {
+		metric := hpaMetricTarget(spec)
+		metric.Current = "<unknown>"
+		if value, ok := current[metric.Type+"/"+metric.Name]; ok {
+			metric.Current = value
+		}
+		metrics = append(metrics, metric)
+	}
+
+	conditions := []HPACondition{}
+	for _, condition := range hpa.Status.Conditions {
+		conditions = append(conditions, HPACondition{
+			Type:    string(condition.Type),
+			Status:  string(condition.Status),
+			Reason:  condition.Reason,
+			Message: condition.Message,
+		})
+	}
+
+	return HPAResource{
+		Name:            hpa.Name,
+		Namespace:       hpa.Namespace,
+		TargetKind:      hpa.Spec.ScaleTargetRef.Kind,
+		TargetName:      hpa.Spec.ScaleTargetRef.Name,
+		MinReplicas:     minReplicas,
+		MaxReplicas:     hpa.Spec.MaxReplicas,
+		CurrentReplicas: hpa.Status.CurrentReplicas,
+		DesiredReplicas: hpa.Status.DesiredReplicas,
+		Metrics:         metrics,
+		Conditions:      conditions,
+		Age:             formatDuration(time.Since(hpa.CreationTimestamp.Time)),
+		Labels:          hpa.Labels,
+		ResourceType:    "HorizontalPodAutoscaler",
+		createdAt:       hpa.CreationTimestamp.Time,
+	}
+}
+
+// hpaMetricTarget describes what a metric spec asks for, e.g. "cpu" at "80%"
+func hpaMetricTarget(spec autoscalingv2.MetricSpec) HPAMetric {
+	metric := HPAMetric{Type: string(spec.Type)}
+	switch spec.Type {
+	case autoscalingv2.ResourceMetricSourceType:
+		if spec.Resource != nil {
+			metric.Name = string(spec.Resource.Name)
+			metric.Target = metricTarget(spec.Resource.Target)
+		}
+	case autoscalingv2.ContainerResourceMetricSourceType:
+		if spec.ContainerResource != nil {
+			metric.Name = spec.ContainerResource.Container + "/" + string(spec.ContainerResource.Name)
+			metric.Target = metricTarget(spec.ContainerResource.Target)
+		}
+	case autoscalingv2.PodsMetricSourceType:
+		if spec.Pods != nil {
+			metric.Name = spec.Pods.Metric.Name
+			metric.Target = metricTarget(spec.Pods.Target)
+		}
+	case autoscalingv2.ObjectMetricSourceType:
+		if spec.Object != nil {
+			metric.Name = spec.Object.DescribedObject.Kind + "/" + spec.Object.DescribedObject.Name + " " + spec.Object.Metric.Name
+			metric.Target = metricTarget(spec.Object.Target)
+		}
+	case autoscalingv2.ExternalMetricSourceType:
+		if spec.External != nil {
+			metric.Name = spec.External.Metric.Name
+			metric.Target = metricTarget(spec.External.Target)
+		}
+	}
+	return metric
+}
+
+// hpaMetricCurrent describes the value the autoscaler last observed for a
+// metric, named the same way as hpaMetricTarget names its spec
+func hpaMetricCurrent(status autoscalingv2.MetricStatus) HPAMetric {
+	metric := HPAMetric{Type: string(status.Type), Current: "<unknown>"}
+	switch status.Type {
+	case autoscalingv2.ResourceMetricSourceType:
+		if status.Resource != nil {
+			metric.Name = string(status.Resource.Name)
+			metric.Current = metricValue(status.Resource.Current)
+		}
+	case autoscalingv2.ContainerResourceMetricSourceType:
+		if status.ContainerResource != nil {
+			metric.Name = status.ContainerResource.Container + "/" + string(status.ContainerResource.Name)
+			metric.Current = metricValue(status.ContainerResource.Current)
+		}
+	case autoscalingv2.PodsMetricSourceType:
+		if status.Pods != nil {
+			metric.Name = status.Pods.Metric.Name
+			metric.Current = metricValue(status.Pods.Current)
+		}
+	case autoscalingv2.ObjectMetricSourceType:
+		if status.Object != nil {
+			metric.Name = status.Object.DescribedObject.Kind + "/" + status.Object.DescribedObject.Name + " " + status.Object.Metric.Name
+			metric.Current = metricValue(status.Object.Current)
+		}
+	case autoscalingv2.ExternalMetricSourceType:
+		if status.External != nil {
+			metric.Name = status.External.Metric.Name
+			metric.Current = metricValue(status.External.Current)
+		}
+	}
+	return metric
+}
+
+func metricTarget(target autoscalingv2.MetricTarget) string {
+	switch {
+	case target.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *target.AverageUtilization)
+	case target.AverageValue != nil:
+		return target.AverageValue.String() + " (avg)"
+	case target.Value != nil:
+		return target.Value.String()
+	}
+	return "<unknown>"
+}
+
+func metricValue(value autoscalingv2.MetricValueStatus) string {
+	switch {
+	case value.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *value.AverageUtilization)
+	case value.AverageValue != nil:
+		return value.AverageValue.String() + " (avg)"
+	case value.Value != nil:
+		return value.Value.String()
+	}
+	return "<unknown>"
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"strings"
+
+	authenticationv1alpha1 "k8s.io/api/authentication/v1alpha1"
+	authenticationv1beta1 "k8s.io/api/authentication/v1beta1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// userIdentity is who the API server knows a session's credentials as,
+// rather than the name of the kubeconfig entry holding them
+type userIdentity struct {
+	Username string
+	Groups   []string
+	UID      string
+}
+
+// resolveIdentity asks the API server who the credentials belong to with a
+// SelfSubjectReview. Clusters that do not serve the review (before 1.27, or
+// 1.26 without the alpha API) fall back to the client certificate's CN and O,
+// then to the bearer token's claims and finally to fallback.
+func resolveIdentity(clientset *kubernetes.Clientset, restConfig *rest.Config, fallback string) userIdentity {
+	if identity, err := selfSubjectReview(clientset); err == nil && identity.Username != "" {
+		return identity
+	}
+	if identity, ok := certificateIdentity(restConfig); ok {
+		return identity
+	}
+	if identity, ok := tokenIdentity(restConfig); ok {
+		return identity
+	}
+	return userIdentity{Username: fallback}
+}
+
+// checkCredentials reports whether the cluster accepts the credentials. Any
+// authenticated user may create a SelfSubjectAccessReview, so, unlike a probe
+// such as listing namespaces, it works for users with only namespace-scoped
+// access. Only an Unauthorized answer means the credentials are bad; other
+// errors mean the cluster could not be asked.
+func checkCredentials(clientset *kubernetes.Clientset) error {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "list", Resource: "namespaces"},
+		},
+	}
+	_, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+	if apierrors.IsForbidden(err) {
+		// Authenticated, but not even allowed to review itself
+		return nil
+	}
+	return err
+}
+
+func selfSubjectReview(clientset *kubernetes.Clientset) (userIdentity, error) {
+	review, err := clientset.AuthenticationV1beta1().SelfSubjectReviews().Create(context.TODO(), &authenticationv1beta1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err == nil {
+		user := review.Status.UserInfo
+		return userIdentity{Username: user.Username, Groups: user.Groups, UID: user.UID}, nil
+	}
+	alphaReview, alphaErr := clientset.AuthenticationV1alpha1().SelfSubjectReviews().Create(context.TODO(), &authenticationv1alpha1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if alphaErr != nil {
+		return userIdentity{}, err
+	}
+	user := alphaReview.Status.UserInfo
+	return userIdentity{Username: user.Username, Groups: user.Groups, UID: user.UID}, nil
+}
+
+// certificateIdentity reads the client certificate the way the API server
+// does: the common name is the user and each organization a group
+func certificateIdentity(restConfig *rest.Config) (userIdentity, bool) {
+	cert := clientCertificate(restConfig)
+	if cert == nil || cert.Subject.CommonName == "" {
+		return userIdentity{}, false
+	}
+	return userIdentity{Username: cert.Subject.CommonName, Groups: cert.Subject.Organization}, true
+}
+
+// tokenIdentity reads the claims of a JWT bearer token without verifying it,
+// which is enough to name the user. Service account tokens carry the
+// account's UID.
+func tokenIdentity(restConfig *rest.Config) (userIdentity, bool) {
+	token := bearerToken(restConfig)
+	if token == "" {
+		return userIdentity{}, false
+	}
+	_, claims, _, err := parseJWT(token)
+	if err != nil {
+		return userIdentity{}, false
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return userIdentity{}, false
+	}
+
+	identity := userIdentity{Username: subject}
+	if groups, ok := claims["groups"].([]interface{}); ok {
+		for _, group := range groups {
+			if group, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, group)
+			}
+		}
+	}
+	if k8s, ok := claims["kubernetes.io"].(map[string]interface{}); ok {
+		if serviceAccount, ok := k8s["serviceaccount"].(map[string]interface{}); ok {
+			identity.UID, _ = serviceAccount["uid"].(string)
+		}
+	}
+	// Legacy service account token Secrets use a flat claim
+	if identity.UID == "" {
+		identity.UID, _ = claims["kubernetes.io/serviceaccount/service-account.uid"].(string)
+	}
+	return identity, true
+}
+
+// clientCertificate parses the client certificate of a client configuration,
+// or returns nil when it has none
+func clientCertificate(restConfig *rest.Config) *x509.Certificate {
+	certData := restConfig.TLSClientConfig.CertData
+	if len(certData) == 0 && restConfig.TLSClientConfig.CertFile != "" {
+		certData, _ = os.ReadFile(restConfig.TLSClientConfig.CertFile)
+	}
+	block, _ := pem.Decode(certData)
+	if block == nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// bearerToken returns the bearer token of a client configuration, read from
+// its token file when there is one
+func bearerToken(restConfig *rest.Config) string {
+	if restConfig.BearerToken != "" || restConfig.BearerTokenFile == "" {
+		return restConfig.BearerToken
+	}
+	data, _ := os.ReadFile(restConfig.BearerTokenFile)
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// authModeEnv selects how sessions reach the cluster: "kubeconfig" (the
+// default) from uploaded kubeconfigs, or "incluster" with the dashboard's
+// service account impersonating the user an authenticating proxy vouches for
+const authModeEnv = "AUTH_MODE"
+
+const (
+	authModeKubeconfig = "kubeconfig"
+	authModeInCluster  = "incluster"
+)
+
+// The proxy headers naming the authenticated user and their comma-separated
+// groups, as sent by oauth2-proxy and most other authenticating proxies
+const (
+	proxyUserHeaderEnv       = "AUTH_PROXY_USER_HEADER"
+	proxyGroupsHeaderEnv     = "AUTH_PROXY_GROUPS_HEADER"
+	defaultProxyUserHeader   = "X-Forwarded-User"
+	defaultProxyGroupsHeader = "X-Forwarded-Groups"
+)
+
+// trustedProxiesEnv lists the comma-separated CIDRs proxy headers are accepted
+// from. Anyone else could set them, so only loopback is trusted by default.
+const trustedProxiesEnv = "AUTH_TRUSTED_PROXIES"
+
+const defaultTrustedProxies = "127.0.0.1/32,::1/128"
+
+// inClusterContext is the context name of in-cluster sessions
+const inClusterContext = "in-cluster"
+
+func authMode() string {
+	if mode := strings.TrimSpace(os.Getenv(authModeEnv)); mode != "" {
+		return mode
+	}
+	return authModeKubeconfig
+}
+
+// CheckAuthMode validates the authentication settings at startup, so a
+// misconfigured deployment fails before it serves anyone
+func CheckAuthMode() error {
+	if err := checkExecPolicy(); err != nil {
+		return err
+	}
+	if err := checkSessionTimeouts(); err != nil {
+		return err
+	}
+	switch authMode() {
+	case authModeKubeconfig:
+		return checkOIDCConfig()
+	case authModeInCluster:
+		if _, err := rest.InClusterConfig(); err != nil {
+			return fmt.Errorf("%s=%s needs to run in a pod: %v", authModeEnv, authModeInCluster, err)
+		}
+		_, err := trustedProxies()
+		return err
+	default:
+		return fmt.Errorf("invalid %s %q: must be %s or %s", authModeEnv, authMode(), authModeKubeconfig, authModeInCluster)
+	}
+}
+
+// GetAuthMode tells the login page whether to offer a kubeconfig upload and
+// an OIDC login
+func GetAuthMode(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"mode": authMode(), "oidc": oidcEnabled()})
+}
+
+// InClusterLogin starts an in-cluster session for the user named by the
+// trusted proxy's headers. Requests are then made with the dashboard's service
+// account impersonating that user and groups, so their RBAC still applies.
+func InClusterLogin(c *gin.Context) {
+	if authMode() != authModeInCluster {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "In-cluster login is not enabled"})
+		return
+	}
+	trusted, err := fromTrustedProxy(c.Request)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	username := strings.TrimSpace(c.GetHeader(proxyUserHeader()))
+	if !trusted || username == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	var groups []string
+	for _, value := range c.Request.Header.Values(proxyGroupsHeader()) {
+		for _, group := range strings.Split(value, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	session := SessionData{
+		Context:   inClusterContext,
+		Username:  username,
+		Groups:    groups,
+		CreatedAt: time.Now(),
+	}
+	renewSession(&session)
+	// The service account must be allowed to impersonate for this to work
+	clientset, err := inClusterClientset(session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build in-cluster client: " + err.Error()})
+		return
+	}
+	if _, err := clientset.Discovery().ServerVersion(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to interact with cluster: " + err.Error()})
+		return
+	}
+
+	if existingToken, err := c.Cookie("sessionToken"); err == nil {
+		deleteSession(existingToken)
+		forgetPermissions(existingToken)
+	}
+	sessionToken := newSessionToken()
+	saveSession(sessionToken, session)
+	c.SetCookie("sessionToken", sessionToken, sessionCookieMaxAge(session), "/", "", false, true)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Logged in through the in-cluster service account",
+		"user":    username,
+		"groups":  groups,
+		"context": inClusterContext,
+	})
+}
+
+// sessionClientset builds the client a request works with: an impersonating
+// in-cluster client for in-cluster sessions, or else one from the session's
+// kubeconfig. OIDC tokens about to expire are refreshed first.
+func sessionClientset(c *gin.Context, session SessionData) (*kubernetes.Clientset, error) {
+	if session.Context == inClusterContext && session.KubeconfigContent == "" {
+		return inClusterClientset(session)
+	}
+	if session.OIDC != nil {
+		sessionToken, _ := c.Cookie("sessionToken")
+		refreshed, err := refreshOIDCSession(sessionToken, session)
+		if err != nil {
+			return nil, err
+		}
+		session = refreshed
+	}
+	return validateKubeConfig(session.KubeconfigContent, requestContext(c, session))
+}
+
+func inClusterClientset(session SessionData) (*kubernetes.Clientset, error) {
+	if authMode() != authModeInCluster {
+		return nil, fmt.Errorf("in-cluster mode is not enabled")
+	}
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error getting in-cluster config: %v", err)
+	}
+	restConfig.Impersonate = rest.ImpersonationConfig{
+		UserName: session.Username,
+		Groups:   session.Groups,
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// fromTrustedProxy reports whether the request's direct peer is a trusted
+// proxy. The peer address is used rather than X-Forwarded-For, which the
+// client controls.
+func fromTrustedProxy(r *http.Request) (bool, error) {
+	networks, err := trustedProxies()
+	if err != nil {
+		return false, err
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	for _, network := range networks {
+		if ip != nil && network.Contains(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func trustedProxies() ([]*net.IPNet, error) {
+	raw := strings.TrimSpace(os.Getenv(trustedProxiesEnv))
+	if raw == "" {
+		raw = defaultTrustedProxies
+	}
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(raw, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", trustedProxiesEnv, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func proxyUserHeader() string {
+	if header := strings.TrimSpace(os.Getenv(proxyUserHeaderEnv)); header != "" {
+		return header
+	}
+	return defaultProxyUserHeader
+}
+
+func proxyGroupsHeader() string {
+	if header := strings.TrimSpace(os.Getenv(proxyGroupsHeaderEnv)); header != "" {
+		return header
+	}
+	return defaultProxyGroupsHeader
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// maxListLimit caps the page size a client can ask for
+const maxListLimit = 5000
+
+// listOptionsFromQuery builds the ListOptions passed to the API server from
+// the labelSelector, fieldSelector, limit and continue query parameters.
+// Selectors are validated here so a malformed one is reported as a bad request.
+// Continue tokens belong to a single list call, so pagination is refused when
+// several namespaces are requested.
+func listOptionsFromQuery(c *gin.Context, namespaces []string) (metav1.ListOptions, error) {
+	var opts metav1.ListOptions
+
+	if raw := strings.TrimSpace(c.Query("labelSelector")); raw != "" {
+		selector, err := labels.Parse(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid labelSelector: %v", err)
+		}
+		opts.LabelSelector = selector.String()
+	}
+
+	if raw := strings.TrimSpace(c.Query("fieldSelector")); raw != "" {
+		selector, err := fields.ParseSelector(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid fieldSelector: %v", err)
+		}
+		opts.FieldSelector = selector.String()
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			return opts, fmt.Errorf("invalid limit: must be between 1 and %d", maxListLimit)
+		}
+		opts.Limit = limit
+	}
+	opts.Continue = c.Query("continue")
+
+	if (opts.Limit > 0 || opts.Continue != "") && len(namespaces) > 1 {
+		return opts, fmt.Errorf("pagination is only supported for a single namespace or all namespaces")
+	}
+
+	return opts, nil
+}
+
+// listSort is how a page of results is ordered before it is returned
+type listSort struct {
+	By         string
+	Descending bool
+}
+
+// sortKey holds the fields a list can be sorted on
+type sortKey struct {
+	namespace string
+	name      string
+	created   time.Time
+	restarts  int32
+	cpu       int64
+	memory    int64
+}
+
+// listSortFromQuery reads the sortBy and order query parameters. sortable
+// lists the sortBy values the endpoint supports besides name.
+func listSortFromQuery(c *gin.Context, sortable ...string) (listSort, error) {
+	s := listSort{By: c.DefaultQuery("sortBy", "name")}
+
+	valid := s.By == "name"
+	for _, by := range sortable {
+		if s.By == by {
+			valid = true
+		}
+	}
+	if !valid {
+		return s, fmt.Errorf("invalid sortBy: %q", s.By)
+	}
+
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		s.Descending = true
+	default:
+		return s, fmt.Errorf("invalid order: must be asc or desc")
+	}
+
+	return s, nil
+}
+
+// less orders a before b. Ties are broken by namespace and name so results
+// from several namespaces stay grouped.
+func (s listSort) less(a, b sortKey) bool {
+	var cmp int
+	switch s.By {
+	case "age":
+		// Youngest first, like sorting the Age column ascending
+		if a.created.After(b.created) {
+			cmp = -1
+		} else if a.created.Before(b.created) {
+			cmp = 1
+		}
+	case "restarts":
+		cmp = compareInt64(int64(a.restarts), int64(b.restarts))
+	case "cpu":
+		cmp = compareInt64(a.cpu, b.cpu)
+	case "memory":
+		cmp = compareInt64(a.memory, b.memory)
+	}
+
+	if cmp == 0 {
+		if a.namespace == b.namespace && a.name == b.name {
+			return false
+		}
+		cmp = 1
+		if namespacedLess(a.namespace, a.name, b.namespace, b.name) {
+			cmp = -1
+		}
+	}
+
+	if s.Descending {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// metricsAPIPath is where metrics-server serves the metrics.k8s.io API
+const metricsAPIPath = "/apis/metrics.k8s.io/v1beta1"
+
+// The subset of metrics.k8s.io/v1beta1 the dashboard reads. They are decoded
+// by hand to avoid depending on the metrics client for two list calls.
+type podMetricsList struct {
+	Items []podMetrics `json:"items"`
+}
+
+type podMetrics struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Containers        []containerMetrics `json:"containers"`
+}
+
+type containerMetrics struct {
+	Name  string              `json:"name"`
+	Usage corev1.ResourceList `json:"usage"`
+}
+
+type nodeMetricsList struct {
+	Items []nodeMetrics `json:"items"`
+}
+
+type nodeMetrics struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Usage             corev1.ResourceList `json:"usage"`
+}
+
+// ResourceUsage is the current CPU and memory use of a pod or node, as
+// `kubectl top` reports it, with percentages of what it asked for
+type ResourceUsage struct {
+	CPU                        string `json:"cpu"`
+	Memory                     string `json:"memory"`
+	CPUPercentOfRequest        *int64 `json:"cpuPercentOfRequest,omitempty"`
+	CPUPercentOfLimit          *int64 `json:"cpuPercentOfLimit,omitempty"`
+	MemoryPercentOfRequest     *int64 `json:"memoryPercentOfRequest,omitempty"`
+	MemoryPercentOfLimit       *int64 `json:"memoryPercentOfLimit,omitempty"`
+	CPUPercentOfAllocatable    *int64 `json:"cpuPercentOfAllocatable,omitempty"`
+	MemoryPercentOfAllocatable *int64 `json:"memoryPercentOfAllocatable,omitempty"`
+
+	cpuMillis   int64
+	memoryBytes int64
+}
+
+// getPodMetrics returns the summed container usage of every pod in namespace,
+// keyed by namespace/name. An error usually means metrics-server is not installed.
+func getPodMetrics(clientset *kubernetes.Clientset, namespace string, opts metav1.ListOptions) (map[string]corev1.ResourceList, error) {
+	path := metricsAPIPath + "/pods"
+	if namespace != metav1.NamespaceAll {
+		path = metricsAPIPath + "/namespaces/" + namespace + "/pods"
+	}
+	request := clientset.CoreV1().RESTClient().Get().AbsPath(path)
+	if opts.LabelSelector != "" {
+		request = request.Param("labelSelector", opts.LabelSelector)
+	}
+	raw, err := request.DoRaw(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("metrics unavailable: %v", err)
+	}
+
+	var list podMetricsList
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("metrics unavailable: error decoding pod metrics: %v", err)
+	}
+
+	usage := make(map[string]corev1.ResourceList, len(list.Items))
+	for _, item := range list.Items {
+		total := corev1.ResourceList{}
+		for _, container := range item.Containers {
+			addResourceList(total, container.Usage)
+		}
+		usage[item.Namespace+"/"+item.Name] = total
+	}
+	return usage, nil
+}
+
+// getPodMetricsIn is getPodMetrics for each of namespaces. metrics.k8s.io
+// cannot select pods by node, so the pods of one node are looked up in the
+// namespaces they run in rather than across the whole cluster.
+func getPodMetricsIn(clientset *kubernetes.Clientset, namespaces []string) (map[string]corev1.ResourceList, error) {
+	var mu sync.Mutex
+	usage := make(map[string]corev1.ResourceList)
+	errs, _ := listAcrossNamespaces(clientset, namespaces, metav1.ListOptions{}, func(namespace string, opts metav1.ListOptions) error {
+		namespaceUsage, err := getPodMetrics(clientset, namespace, opts)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for key, podUsage := range namespaceUsage {
+			usage[key] = podUsage
+		}
+		return nil
+	})
+	if len(errs) > 0 {
+		return usage, errors.New(joinNamespaceErrors(errs))
+	}
+	return usage, nil
+}
+
+// getNodeMetrics returns the usage of every node keyed by node name
+func getNodeMetrics(clientset *kubernetes.Clientset) (map[string]corev1.ResourceList, error) {
+	raw, err := clientset.CoreV1().RESTClient().Get().AbsPath(metricsAPIPath + "/nodes").DoRaw(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("metrics unavailable: %v", err)
+	}
+
+	var list nodeMetricsList
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("metrics unavailable: error decoding node metrics: %v", err)
+	}
+
+	usage := make(map[string]corev1.ResourceList, len(list.Items))
+	for _, item := range list.Items {
+		usage[item.Name] = item.Usage
+	}
+	return usage, nil
+}
+
+// podUsage compares a pod's usage against its requests and limits. Percentages
+// are left out when the pod does not set the corresponding request or limit.
+func podUsage(pod corev1.Pod, usage corev1.ResourceList) *ResourceUsage {
+	requests, limits := podRequestsAndLimits(pod)
+	result := newResourceUsage(usage)
+	result.CPUPercentOfRequest = usagePercent(result.cpuMillis, requests, corev1.ResourceCPU)
+	result.CPUPercentOfLimit = usagePercent(result.cpuMillis, limits, corev1.ResourceCPU)
+	result.MemoryPercentOfRequest = usagePercent(result.memoryBytes, requests, corev1.ResourceMemory)
+	result.MemoryPercentOfLimit = usagePercent(result.memoryBytes, limits, corev1.ResourceMemory)
+	return result
+}
+
+// nodeUsage compares a node's usage against its allocatable capacity
+func nodeUsage(node corev1.Node, usage corev1.ResourceList) *ResourceUsage {
+	result := newResourceUsage(usage)
+	result.CPUPercentOfAllocatable = usagePercent(result.cpuMillis, node.Status.Allocatable, corev1.ResourceCPU)
+	result.MemoryPercentOfAllocatable = usagePercent(result.memoryBytes, node.Status.Allocatable, corev1.ResourceMemory)
+	return result
+}
+
+func newResourceUsage(usage corev1.ResourceList) *ResourceUsage {
+	cpu := usage[corev1.ResourceCPU]
+	memory := usage[corev1.ResourceMemory]
+	return &ResourceUsage{
+		CPU:         fmt.Sprintf("%dm", cpu.MilliValue()),
+		Memory:      fmt.Sprintf("%dMi", memory.Value()/(1024*1024)),
+		cpuMillis:   cpu.MilliValue(),
+		memoryBytes: memory.Value(),
+	}
+}
+
+// usagePercent returns used as a percentage of list[name], in millicores for
+// CPU and bytes for everything else, or nil when list does not set name
+func usagePercent(used int64, list corev1.ResourceList, name corev1.ResourceName) *int64 {
+	quantity, ok := list[name]
+	if !ok || quantity.IsZero() {
+		return nil
+	}
+	total := quantity.Value()
+	if name == corev1.ResourceCPU {
+		total = quantity.MilliValue()
+	}
+	percent := percentOf(used, total)
+	return &percent
+}
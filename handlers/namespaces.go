@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// allNamespacesParam is the :namespace route value that selects every namespace
+const allNamespacesParam = "_all"
+
+// maxNamespaceConcurrency caps how many namespaces are listed in parallel
+const maxNamespaceConcurrency = 10
+
+// ResourceList is the response envelope of the list endpoints. Errors holds
+// the namespaces that could not be listed, so callers still get partial results
+// when they lack access to some of them. The selectors echo what was applied,
+// and Continue is set when there are more items to fetch with ?continue=.
+// Warnings report optional data that could not be added, such as metrics.
+// ClusterErrors holds the clusters that failed when a list fans out across
+// clusters.
+type ResourceList struct {
+	Items              interface{}       `json:"items"`
+	Errors             map[string]string `json:"errors,omitempty"`
+	ClusterErrors      map[string]string `json:"clusterErrors,omitempty"`
+	LabelSelector      string            `json:"labelSelector,omitempty"`
+	FieldSelector      string            `json:"fieldSelector,omitempty"`
+	Continue           string            `json:"continue,omitempty"`
+	RemainingItemCount *int64            `json:"remainingItemCount,omitempty"`
+	Warnings           []string          `json:"warnings,omitempty"`
+}
+
+// parseNamespaces expands the :namespace route parameter, which is either a
+// single namespace, a comma-separated set or "_all". All namespaces is
+// returned as the single empty namespace.
+func parseNamespaces(param string) []string {
+	if param == allNamespacesParam {
+		return []string{metav1.NamespaceAll}
+	}
+
+	seen := make(map[string]bool)
+	var namespaces []string
+	for _, ns := range strings.Split(param, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces
+}
+
+// listAcrossNamespaces calls list once per namespace concurrently with opts
+// and returns the error of every namespace that failed. ok is false when
+// nothing could be listed at all. When a cluster-wide list is forbidden it
+// falls back to listing namespace by namespace, keeping whatever the user can
+// see. The fallback lists each namespace in full: a limit per namespace would
+// silently cut every one of them short, and the page could not be continued
+// or sorted as a whole.
+func listAcrossNamespaces(clientset *kubernetes.Clientset, namespaces []string, opts metav1.ListOptions, list func(namespace string, opts metav1.ListOptions) error) (map[string]string, bool) {
+	if len(namespaces) == 1 && namespaces[0] == metav1.NamespaceAll {
+		err := list(metav1.NamespaceAll, opts)
+		if err == nil {
+			return nil, true
+		}
+		if !apierrors.IsForbidden(err) {
+			return map[string]string{allNamespacesParam: err.Error()}, false
+		}
+
+		nsList, nsErr := clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+		if nsErr != nil {
+			return map[string]string{allNamespacesParam: err.Error()}, false
+		}
+		namespaces = nil
+		for _, ns := range nsList.Items {
+			namespaces = append(namespaces, ns.Name)
+		}
+		opts.Limit = 0
+		opts.Continue = ""
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(map[string]string)
+	sem := make(chan struct{}, maxNamespaceConcurrency)
+	for _, ns := range namespaces {
+		wg.Add(1)
+		go func(namespace string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := list(namespace, opts); err != nil {
+				mu.Lock()
+				errs[namespace] = err.Error()
+				mu.Unlock()
+			}
+		}(ns)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil, true
+	}
+	return errs, len(errs) < len(namespaces)
+}
+
+// listRequest is what a list endpoint reads from its request: the namespaces
+// of the :namespace parameter, the options each of them is listed with and
+// the order of the merged rows
+type listRequest struct {
+	namespaces []string
+	opts       metav1.ListOptions
+	order      listSort
+}
+
+// parseListRequest reads the namespaces, selectors, pagination and sort order
+// of a list endpoint, answering 400 when the query is invalid. sortable lists
+// the sortBy values the endpoint supports besides name.
+func parseListRequest(c *gin.Context, sortable ...string) (listRequest, bool) {
+	request := listRequest{namespaces: parseNamespaces(c.Param("namespace"))}
+	var err error
+	if request.opts, err = listOptionsFromQuery(c, request.namespaces); err == nil {
+		request.order, err = listSortFromQuery(c, sortable...)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return request, false
+	}
+	return request, true
+}
+
+// sortableRow is a row of a list endpoint
+type sortableRow interface {
+	sortKey() sortKey
+}
+
+// namespacePage is what listing one namespace returns: its rows, the list
+// metadata and warnings about optional data that could not be added
+type namespacePage[T sortableRow] struct {
+	items    []T
+	meta     metav1.ListMeta
+	warnings []string
+}
+
+// listPages lists every namespace of request with list and merges the pages.
+// Rows are sorted by the request's order and repeated warnings are dropped.
+// The merged page only keeps a continue token when everything came from one
+// list call.
+func listPages[T sortableRow](clientset *kubernetes.Clientset, request listRequest, list func(namespace string, opts metav1.ListOptions) (namespacePage[T], error)) (namespacePage[T], map[string]string, bool) {
+	var mu sync.Mutex
+	var pages int
+	merged := namespacePage[T]{items: []T{}}
+	errs, ok := listAcrossNamespaces(clientset, request.namespaces, request.opts, func(namespace string, opts metav1.ListOptions) error {
+		page, err := list(namespace, opts)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		pages++
+		merged.meta = page.meta
+		merged.items = append(merged.items, page.items...)
+		for _, warning := range page.warnings {
+			if !containsString(merged.warnings, warning) {
+				merged.warnings = append(merged.warnings, warning)
+			}
+		}
+		return nil
+	})
+	if pages != 1 {
+		merged.meta = metav1.ListMeta{}
+	}
+
+	sort.SliceStable(merged.items, func(i, j int) bool {
+		return request.order.less(merged.items[i].sortKey(), merged.items[j].sortKey())
+	})
+	return merged, errs, ok
+}
+
+// respondList answers a list endpoint with the rows list returns for every
+// namespace of request, or 500 when no namespace could be listed
+func respondList[T sortableRow](c *gin.Context, clientset *kubernetes.Clientset, request listRequest, list func(namespace string, opts metav1.ListOptions) (namespacePage[T], error)) {
+	page, errs, ok := listPages(clientset, request, list)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": joinNamespaceErrors(errs)})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, ResourceList{
+		Items:              page.items,
+		Errors:             errs,
+		LabelSelector:      request.opts.LabelSelector,
+		FieldSelector:      request.opts.FieldSelector,
+		Continue:           page.meta.Continue,
+		RemainingItemCount: page.meta.RemainingItemCount,
+		Warnings:           page.warnings,
+	})
+}
+
+// joinNamespaceErrors flattens per-namespace errors into a single message
+func joinNamespaceErrors(errs map[string]string) string {
+	if len(errs) == 1 {
+		for _, msg := range errs {
+			return msg
+		}
+	}
+
+	var messages []string
+	for ns, msg := range errs {
+		messages = append(messages, ns+": "+msg)
+	}
+	sort.Strings(messages)
+	return strings.Join(messages, "; ")
+}
+
+// namespacedLess orders resources by namespace and then by name, so results
+// from several namespaces come back grouped
+func namespacedLess(nsA, nameA, nsB, nameB string) bool {
+	if nsA != nsB {
+		return nsA < nsB
+	}
+	return nameA < nameB
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// testSession saves a session whose kubeconfig points at server and returns
+// its token
+func testSession(t *testing.T, server string) string {
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["dev"] = &clientcmdapi.Cluster{Server: server}
+	kubeconfig.AuthInfos["dev"] = &clientcmdapi.AuthInfo{}
+	kubeconfig.Contexts["dev"] = &clientcmdapi.Context{Cluster: "dev", AuthInfo: "dev"}
+	kubeconfig.CurrentContext = "dev"
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionToken := newSessionToken()
+	saveSession(sessionToken, SessionData{KubeconfigContent: string(content), Context: "dev", ExpiresAt: time.Now().Add(time.Hour)})
+	t.Cleanup(func() { deleteSession(sessionToken) })
+	return sessionToken
+}
+
+// restrictedAPIServer serves Deployments in two namespaces to a user who may
+// not list them cluster-wide, honoring limit like the real API server does
+func restrictedAPIServer(t *testing.T, created time.Time) *httptest.Server {
+	deployments := map[string][]appsv1.Deployment{}
+	for i, ns := range []string{"ns-a", "ns-b"} {
+		for j := 0; j < 3; j++ {
+			deployments[ns] = append(deployments[ns], appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("app-%d", j),
+				Namespace: ns,
+				// ns-b's Deployments interleave with ns-a's by age
+				CreationTimestamp: metav1.NewTime(created.Add(time.Duration(2*j+i) * time.Minute)),
+			}})
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case r.URL.Path == "/apis/apps/v1/deployments":
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Reason: metav1.StatusReasonForbidden, Code: http.StatusForbidden})
+		case r.URL.Path == "/api/v1/namespaces":
+			json.NewEncoder(w).Encode(corev1.NamespaceList{
+				TypeMeta: metav1.TypeMeta{Kind: "NamespaceList", APIVersion: "v1"},
+				Items:    []corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "ns-a"}}, {ObjectMeta: metav1.ObjectMeta{Name: "ns-b"}}},
+			})
+		case len(parts) == 6 && parts[5] == "deployments":
+			list := appsv1.DeploymentList{TypeMeta: metav1.TypeMeta{Kind: "DeploymentList", APIVersion: "apps/v1"}, Items: deployments[parts[4]]}
+			if limit, _ := strconv.Atoi(r.URL.Query().Get("limit")); limit > 0 && limit < len(list.Items) {
+				list.Items = list.Items[:limit]
+				list.Continue = "more"
+			}
+			json.NewEncoder(w).Encode(list)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestListAcrossNamespacesFallback(t *testing.T) {
+	server := restrictedAPIServer(t, time.Now().Add(-time.Hour))
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	listed := map[string]int{}
+	errs, ok := listAcrossNamespaces(clientset, parseNamespaces(allNamespacesParam), metav1.ListOptions{Limit: 2, Continue: "token"}, func(namespace string, opts metav1.ListOptions) error {
+		if namespace != metav1.NamespaceAll && (opts.Limit != 0 || opts.Continue != "") {
+			t.Errorf("%s was listed with limit %d and continue %q", namespace, opts.Limit, opts.Continue)
+		}
+		opts.Continue = ""
+		deployments, err := clientset.AppsV1().Deployments(namespace).List(context.Background(), opts)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		listed[namespace] = len(deployments.Items)
+		mu.Unlock()
+		return nil
+	})
+	if !ok || len(errs) != 0 {
+		t.Fatalf("fallback failed: %v", errs)
+	}
+	if listed["ns-a"] != 3 || listed["ns-b"] != 3 {
+		t.Errorf("listed %v, want every Deployment of both namespaces", listed)
+	}
+}
+
+func TestGetDeploymentsFallbackSorting(t *testing.T) {
+	server := restrictedAPIServer(t, time.Now().Add(-time.Hour))
+	sessionToken := testSession(t, server.URL)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "youngest first", query: "sortBy=age", want: []string{"ns-b/app-2", "ns-a/app-2", "ns-b/app-1", "ns-a/app-1", "ns-b/app-0", "ns-a/app-0"}},
+		{name: "oldest first", query: "sortBy=age&order=desc", want: []string{"ns-a/app-0", "ns-b/app-0", "ns-a/app-1", "ns-b/app-1", "ns-a/app-2", "ns-b/app-2"}},
+		{name: "limit does not truncate each namespace", query: "sortBy=age&limit=2", want: []string{"ns-b/app-2", "ns-a/app-2", "ns-b/app-1", "ns-a/app-1", "ns-b/app-0", "ns-a/app-0"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/deployments/namespace/:namespace", GetDeployments)
+			request := httptest.NewRequest(http.MethodGet, "/deployments/namespace/"+allNamespacesParam+"?"+test.query, nil)
+			request.AddCookie(&http.Cookie{Name: "sessionToken", Value: sessionToken})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", w.Code, w.Body.String())
+			}
+
+			var response struct {
+				Items []struct {
+					Name      string `json:"name"`
+					Namespace string `json:"namespace"`
+				} `json:"items"`
+				Continue string `json:"continue"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, item := range response.Items {
+				got = append(got, item.Namespace+"/"+item.Name)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			if response.Continue != "" {
+				t.Errorf("got continue token %q from a merged list", response.Continue)
+			}
+		})
+	}
+}
+
+func TestListPages(t *testing.T) {
+	remaining := int64(4)
+	list := func(namespace string, opts metav1.ListOptions) (namespacePage[ServiceResource], error) {
+		if namespace == "denied" {
+			return namespacePage[ServiceResource]{}, fmt.Errorf("forbidden")
+		}
+		return namespacePage[ServiceResource]{
+			items:    []ServiceResource{{Namespace: namespace, Name: "b"}, {Namespace: namespace, Name: "a"}},
+			meta:     metav1.ListMeta{Continue: "next-" + namespace, RemainingItemCount: &remaining},
+			warnings: []string{"workloads unavailable"},
+		}, nil
+	}
+
+	tests := []struct {
+		name         string
+		namespaces   []string
+		wantItems    []string
+		wantContinue string
+		wantErrors   int
+		wantOK       bool
+	}{
+		{name: "one namespace keeps its continue token", namespaces: []string{"ns-a"}, wantItems: []string{"ns-a/a", "ns-a/b"}, wantContinue: "next-ns-a", wantOK: true},
+		{name: "several namespaces drop it", namespaces: []string{"ns-b", "ns-a"}, wantItems: []string{"ns-a/a", "ns-a/b", "ns-b/a", "ns-b/b"}, wantOK: true},
+		{name: "failed namespaces are reported", namespaces: []string{"ns-a", "denied"}, wantItems: []string{"ns-a/a", "ns-a/b"}, wantContinue: "next-ns-a", wantErrors: 1, wantOK: true},
+		{name: "nothing listed", namespaces: []string{"denied"}, wantItems: nil, wantErrors: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := listRequest{namespaces: test.namespaces, order: listSort{By: "name"}}
+			page, errs, ok := listPages(nil, request, list)
+			if ok != test.wantOK || len(errs) != test.wantErrors {
+				t.Fatalf("got ok %v and errors %v", ok, errs)
+			}
+			var got []string
+			for _, item := range page.items {
+				got = append(got, item.Namespace+"/"+item.Name)
+			}
+			if !reflect.DeepEqual(got, test.wantItems) {
+				t.Errorf("got items %v, want %v", got, test.wantItems)
+			}
+			if page.meta.Continue != test.wantContinue || (test.wantContinue == "") != (page.meta.RemainingItemCount == nil) {
+				t.Errorf("got continue %q with %v remaining", page.meta.Continue, page.meta.RemainingItemCount)
+			}
+			if ok && !reflect.DeepEqual(page.warnings, []string{"workloads unavailable"}) {
+				t.Errorf("got warnings %v", page.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// nodeRoleLabelPrefix is how kubectl derives the ROLES column of a node
+const nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+
+// nodeConditionTypes are the node conditions reported by the dashboard
+var nodeConditionTypes = []corev1.NodeConditionType{
+	corev1.NodeReady,
+	corev1.NodeMemoryPressure,
+	corev1.NodeDiskPressure,
+	corev1.NodePIDPressure,
+}
+
+type NodeCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type NodeResources struct {
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+}
+
+type NodeResource struct {
+	Name                   string          `json:"name"`
+	Status                 string          `json:"status"`
+	Roles                  []string        `json:"roles"`
+	Conditions             []NodeCondition `json:"conditions"`
+	KubeletVersion         string          `json:"kubeletVersion"`
+	OS                     string          `json:"os"`
+	Architecture           string          `json:"architecture"`
+	Allocatable            NodeResources   `json:"allocatable"`
+	Requested              NodeResources   `json:"requested"`
+	CPURequestedPercent    int64           `json:"cpuRequestedPercent"`
+	MemoryRequestedPercent int64           `json:"memoryRequestedPercent"`
+	Taints                 []string        `json:"taints"`
+	PodCount               int             `json:"podCount"`
+	// PodsUnknown is set when the pods could not be listed, which leaves
+	// Requested and PodCount unknown rather than zero
+	PodsUnknown  bool              `json:"podsUnknown,omitempty"`
+	Age          string            `json:"age"`
+	Labels       map[string]string `json:"labels"`
+	ResourceType string            `json:"resourceType"`
+	Usage        *ResourceUsage    `json:"usage,omitempty"`
+
+	createdAt time.Time
+}
+
+func (r NodeResource) sortKey() sortKey {
+	key := sortKey{name: r.Name, created: r.createdAt}
+	if r.Usage != nil {
+		key.cpu = r.Usage.cpuMillis
+		key.memory = r.Usage.memoryBytes
+	}
+	return key
+}
+
+// GetNodes lists the cluster's nodes with their health and how much of their
+// allocatable CPU and memory is requested by the pods scheduled on them
+func GetNodes(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	listOptions, err := listOptionsFromQuery(c, nil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	order, err := listSortFromQuery(c, "age", "cpu", "memory")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), listOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	// Terminated pods no longer hold their requests on the node. Like usage,
+	// the pods are optional: users who may not list them cluster-wide still
+	// see the nodes.
+	var warnings []string
+	podList, podsErr := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "status.phase!=" + string(corev1.PodSucceeded) + ",status.phase!=" + string(corev1.PodFailed),
+	})
+	podsByNode := make(map[string][]corev1.Pod)
+	if podsErr != nil {
+		warnings = append(warnings, "Pod counts and requests are unavailable: "+podsErr.Error())
+	} else {
+		for _, pod := range podList.Items {
+			if pod.Spec.NodeName != "" {
+				podsByNode[pod.Spec.NodeName] = append(podsByNode[pod.Spec.NodeName], pod)
+			}
+		}
+	}
+
+	// Usage is optional: without metrics-server the nodes are still listed
+	usage, metricsErr := getNodeMetrics(clientset)
+	if metricsErr != nil {
+		warnings = append(warnings, metricsErr.Error())
+	}
+
+	resourceList := []NodeResource{}
+	for _, node := range nodes.Items {
+		item := nodeResource(node, podsByNode[node.Name])
+		item.PodsUnknown = podsErr != nil
+		if nodeMetrics, ok := usage[node.Name]; ok {
+			item.Usage = nodeUsage(node, nodeMetrics)
+		}
+		resourceList = append(resourceList, item)
+	}
+
+	sort.SliceStable(resourceList, func(i, j int) bool {
+		return order.less(resourceList[i].sortKey(), resourceList[j].sortKey())
+	})
+	response := ResourceList{
+		Items:              resourceList,
+		LabelSelector:      listOptions.LabelSelector,
+		FieldSelector:      listOptions.FieldSelector,
+		Continue:           nodes.Continue,
+		RemainingItemCount: nodes.RemainingItemCount,
+		Warnings:           warnings,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetNodePods lists the pods scheduled on a node, across all namespaces
+func GetNodePods(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	order, err := listSortFromQuery(c, "age", "restarts", "cpu", "memory")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	nodeName := c.Param("name")
+	podList, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	var namespaces []string
+	for _, pod := range podList.Items {
+		if !containsString(namespaces, pod.Namespace) {
+			namespaces = append(namespaces, pod.Namespace)
+		}
+	}
+	usage, metricsErr := getPodMetricsIn(clientset, namespaces)
+
+	resourceList := []PodResource{}
+	for _, pod := range podList.Items {
+		item := podResource(pod)
+		if podMetrics, ok := usage[pod.Namespace+"/"+pod.Name]; ok {
+			item.Usage = podUsage(pod, podMetrics)
+		}
+		resourceList = append(resourceList, item)
+	}
+
+	sort.SliceStable(resourceList, func(i, j int) bool {
+		return order.less(resourceList[i].sortKey(), resourceList[j].sortKey())
+	})
+	response := ResourceList{Items: resourceList}
+	if metricsErr != nil {
+		response.Warnings = []string{metricsErr.Error()}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// nodeResource computes the dashboard's columns for a single Node. pods are
+// the non-terminated pods scheduled on it.
+func nodeResource(node corev1.Node, pods []corev1.Pod) NodeResource {
+	status := "Unknown"
+	var conditions []NodeCondition
+	for _, conditionType := range nodeConditionTypes {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type != conditionType {
+				continue
+			}
+			conditions = append(conditions, NodeCondition{
+				Type:    string(condition.Type),
+				Status:  string(condition.Status),
+				Reason:  condition.Reason,
+				Message: condition.Message,
+			})
+			if condition.Type == corev1.NodeReady {
+				switch condition.Status {
+				case corev1.ConditionTrue:
+					status = "Ready"
+				case corev1.ConditionFalse:
+					status = "NotReady"
+				}
+			}
+		}
+	}
+	if node.Spec.Unschedulable {
+		status += ",SchedulingDisabled"
+	}
+
+	var roles []string
+	for label := range node.Labels {
+		if strings.HasPrefix(label, nodeRoleLabelPrefix) {
+			if role := strings.TrimPrefix(label, nodeRoleLabelPrefix); role != "" {
+				roles = append(roles, role)
+			}
+		}
+	}
+	sort.Strings(roles)
+
+	var taints []string
+	for _, taint := range node.Spec.Taints {
+		taints = append(taints, taint.ToString())
+	}
+
+	requestedCPU := resource.Quantity{}
+	requestedMemory := resource.Quantity{}
+	for _, pod := range pods {
+		requests, _ := podRequestsAndLimits(pod)
+		requestedCPU.Add(requests[corev1.ResourceCPU])
+		requestedMemory.Add(requests[corev1.ResourceMemory])
+	}
+	allocatableCPU := node.Status.Allocatable[corev1.ResourceCPU]
+	allocatableMemory := node.Status.Allocatable[corev1.ResourceMemory]
+
+	return NodeResource{
+		Name:           node.Name,
+		Status:         status,
+		Roles:          roles,
+		Conditions:     conditions,
+		KubeletVersion: node.Status.NodeInfo.KubeletVersion,
+		OS:             node.Status.NodeInfo.OperatingSystem,
+		Architecture:   node.Status.NodeInfo.Architecture,
+		Allocatable: NodeResources{
+			CPU:    allocatableCPU.String(),
+			Memory: allocatableMemory.String(),
+		},
+		Requested: NodeResources{
+			CPU:    requestedCPU.String(),
+			Memory: requestedMemory.String(),
+		},
+		CPURequestedPercent:    percentOf(requestedCPU.MilliValue(), allocatableCPU.MilliValue()),
+		MemoryRequestedPercent: percentOf(requestedMemory.Value(), allocatableMemory.Value()),
+		Taints:                 taints,
+		PodCount:               len(pods),
+		Age:                    formatDuration(time.Since(node.CreationTimestamp.Time)),
+		Labels:                 node.Labels,
+		ResourceType:           "Node",
+		createdAt:              node.CreationTimestamp.Time,
+	}
+}
+
+// podRequestsAndLimits returns the effective requests and limits of a pod the
+// way the scheduler sees them: the sum of its containers, raised to any single
+// init container that asks for more, plus the pod overhead.
+func podRequestsAndLimits(pod corev1.Pod) (corev1.ResourceList, corev1.ResourceList) {
+	requests := corev1.ResourceList{}
+	limits := corev1.ResourceList{}
+
+	for _, container := range pod.Spec.Containers {
+		addResourceList(requests, container.Resources.Requests)
+		addResourceList(limits, container.Resources.Limits)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		maxResourceList(requests, container.Resources.Requests)
+		maxResourceList(limits, container.Resources.Limits)
+	}
+	if pod.Spec.Overhead != nil {
+		addResourceList(requests, pod.Spec.Overhead)
+		for name, quantity := range pod.Spec.Overhead {
+			if value, ok := limits[name]; ok {
+				value.Add(quantity)
+				limits[name] = value
+			}
+		}
+	}
+
+	return requests, limits
+}
+
+func addResourceList(list, add corev1.ResourceList) {
+	for name, quantity := range add {
+		if value, ok := list[name]; ok {
+			value.Add(quantity)
+			list[name] = value
+		} else {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+func maxResourceList(list, other corev1.ResourceList) {
+	for name, quantity := range other {
+		if value, ok := list[name]; !ok || quantity.Cmp(value) > 0 {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+// percentOf returns value as a whole percentage of total, or 0 when total is unknown
+func percentOf(value, total int64) int64 {
+	if total <= 0 {
+		return 0
+	}
+	return int64(float64(value) / float64(total) * 100)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetNodePodsMetrics(t *testing.T) {
+	// Pods of node-1 run in ns-a and ns-b; metrics-server has no data for ns-b
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/pods":
+			if r.URL.Query().Get("fieldSelector") != "spec.nodeName=node-1" {
+				t.Errorf("pods listed with %q", r.URL.RawQuery)
+			}
+			pod := func(namespace string) corev1.Pod {
+				return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace}, Spec: corev1.PodSpec{NodeName: "node-1", Containers: []corev1.Container{{Name: "app"}}}}
+			}
+			json.NewEncoder(w).Encode(corev1.PodList{TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"}, Items: []corev1.Pod{pod("ns-a"), pod("ns-b")}})
+		case "/apis/metrics.k8s.io/v1beta1/namespaces/ns-a/pods":
+			w.Write([]byte(`{"items":[{"metadata":{"name":"web","namespace":"ns-a"},"containers":[{"name":"app","usage":{"cpu":"100m","memory":"64Mi"}}]}]}`))
+		case "/apis/metrics.k8s.io/v1beta1/pods":
+			t.Errorf("metrics listed for every pod in the cluster")
+			w.Write([]byte(`{"items":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	sessionToken := testSession(t, server.URL)
+
+	router := gin.New()
+	router.GET("/nodes/:name/pods", GetNodePods)
+	request := httptest.NewRequest(http.MethodGet, "/nodes/node-1/pods", nil)
+	request.AddCookie(&http.Cookie{Name: "sessionToken", Value: sessionToken})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Items    []PodResource `json:"items"`
+		Warnings []string      `json:"warnings"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Items) != 2 {
+		t.Fatalf("got %d pods", len(response.Items))
+	}
+	for _, pod := range response.Items {
+		if (pod.Usage != nil) != (pod.Namespace == "ns-a") {
+			t.Errorf("pod %s/%s has usage %+v", pod.Namespace, pod.Name, pod.Usage)
+		}
+	}
+	if len(response.Warnings) != 1 || !strings.Contains(response.Warnings[0], "metrics unavailable") {
+		t.Errorf("got warnings %v", response.Warnings)
+	}
+}
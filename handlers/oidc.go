@@ -0,0 +1,613 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// OIDC login is enabled in kubeconfig mode by setting the issuer. Any issuer
+// with discovery works, including a local mock provider over plain http.
+const (
+	oidcIssuerEnv        = "OIDC_ISSUER_URL"
+	oidcClientIDEnv      = "OIDC_CLIENT_ID"
+	oidcClientSecretEnv  = "OIDC_CLIENT_SECRET"
+	oidcRedirectURLEnv   = "OIDC_REDIRECT_URL"
+	oidcScopesEnv        = "OIDC_SCOPES"
+	oidcUsernameClaimEnv = "OIDC_USERNAME_CLAIM"
+	// The API server the ID tokens are sent to, which must be configured to
+	// accept tokens from the issuer
+	oidcClusterServerEnv = "OIDC_CLUSTER_SERVER"
+	oidcClusterCAFileEnv = "OIDC_CLUSTER_CA_FILE"
+)
+
+const (
+	defaultOIDCScopes        = "openid email profile offline_access"
+	defaultOIDCUsernameClaim = "email"
+	// oidcContext names the context of the kubeconfig built for OIDC sessions
+	oidcContext = "oidc"
+	// oidcLoginTimeout is how long a user has to complete a login at the issuer
+	oidcLoginTimeout = 10 * time.Minute
+	// oidcRefreshMargin is how long before the ID token expires it is refreshed
+	oidcRefreshMargin = 2 * time.Minute
+	// jwksRefreshInterval is the least time between two fetches of the
+	// issuer's key set
+	jwksRefreshInterval = time.Minute
+	// oidcStateCookie ties a login to the browser that started it, so nobody
+	// can complete their own login in someone else's browser
+	oidcStateCookie = "oidcState"
+)
+
+// oidcTokens are the tokens of an OIDC session
+type oidcTokens struct {
+	IDToken      string
+	RefreshToken string
+	Expiry       time.Time
+	// User is the kubeconfig user holding the ID token
+	User string
+}
+
+// oidcLogin is a login waiting for the issuer to redirect back
+type oidcLogin struct {
+	verifier  string
+	nonce     string
+	expiresAt time.Time
+}
+
+// oidcProvider is the part of the issuer's discovery document the login uses
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+var (
+	oidcMu sync.Mutex
+	// oidcLogins is keyed by the state parameter of the login
+	oidcLogins = make(map[string]oidcLogin)
+	provider   *oidcProvider
+	// jwks holds the issuer's signing keys by key ID, as fetched at
+	// jwksFetchedAt
+	jwks          = make(map[string]crypto.PublicKey)
+	jwksFetchedAt time.Time
+	// oidcRefreshLocks serialize the refreshes of each session, since issuers
+	// that rotate refresh tokens reject all but the first use of one
+	oidcRefreshLocks = make(map[string]*sync.Mutex)
+)
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+func oidcEnabled() bool {
+	return authMode() == authModeKubeconfig && os.Getenv(oidcIssuerEnv) != ""
+}
+
+// checkOIDCConfig reports missing OIDC settings at startup
+func checkOIDCConfig() error {
+	if !oidcEnabled() {
+		return nil
+	}
+	for _, env := range []string{oidcClientIDEnv, oidcRedirectURLEnv, oidcClusterServerEnv} {
+		if os.Getenv(env) == "" {
+			return fmt.Errorf("%s is set but %s is not", oidcIssuerEnv, env)
+		}
+	}
+	return nil
+}
+
+// OIDCLogin sends the user to the issuer to log in with the authorization
+// code flow, protected by PKCE
+func OIDCLogin(c *gin.Context) {
+	if !oidcEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "OIDC login is not enabled"})
+		return
+	}
+	p, err := oidcDiscover()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to discover OIDC issuer: " + err.Error()})
+		return
+	}
+
+	state, verifier, nonce := randomString(), randomString(), randomString()
+	oidcMu.Lock()
+	oidcLogins[state] = oidcLogin{verifier: verifier, nonce: nonce, expiresAt: time.Now().Add(oidcLoginTimeout)}
+	oidcMu.Unlock()
+	c.SetCookie(oidcStateCookie, state, int(oidcLoginTimeout.Seconds()), "/oidc", "", false, true)
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {os.Getenv(oidcClientIDEnv)},
+		"redirect_uri":          {os.Getenv(oidcRedirectURLEnv)},
+		"scope":                 {oidcScopes()},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, p.AuthorizationEndpoint+separator+query.Encode())
+}
+
+// OIDCCallback completes a login: it exchanges the code for tokens, verifies
+// the ID token and starts a session whose kubeconfig authenticates with it
+func OIDCCallback(c *gin.Context) {
+	if !oidcEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "OIDC login is not enabled"})
+		return
+	}
+	if issuerErr := c.Query("error"); issuerErr != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "OIDC login failed: " + issuerErr + " " + c.Query("error_description")})
+		return
+	}
+	state := c.Query("state")
+	browserState, err := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, "/oidc", "", false, true)
+	if err != nil || browserState != state {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This OIDC login was not started in this browser, please try again"})
+		return
+	}
+	oidcMu.Lock()
+	login, ok := oidcLogins[state]
+	delete(oidcLogins, state)
+	oidcMu.Unlock()
+	if !ok || time.Now().After(login.expiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown or expired OIDC login, please try again"})
+		return
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {c.Query("code")},
+		"redirect_uri":  {os.Getenv(oidcRedirectURLEnv)},
+		"code_verifier": {login.verifier},
+	}
+	tokens, claims, err := oidcTokenRequest(form, login.nonce)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "OIDC login failed: " + err.Error()})
+		return
+	}
+	username, _ := claims[oidcUsernameClaim()].(string)
+	if username == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "OIDC login failed: the ID token has no " + oidcUsernameClaim() + " claim"})
+		return
+	}
+	tokens.User = username
+	var groups []string
+	if claimed, ok := claims["groups"].([]interface{}); ok {
+		for _, group := range claimed {
+			if group, ok := group.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	kubeconfigContent, err := oidcKubeconfig(tokens)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build kubeconfig: " + err.Error()})
+		return
+	}
+	clientset, err := validateKubeConfig(kubeconfigContent, oidcContext)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid kubeconfig: " + err.Error()})
+		return
+	}
+	if _, err := clientset.Discovery().ServerVersion(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to interact with cluster: " + err.Error()})
+		return
+	}
+	// The API server may prefix the username or read another claim, so it is
+	// asked who the token belongs to. The claims only stand in when it cannot
+	// say, since the token's sub is rarely the username RBAC sees.
+	identity := userIdentity{Username: username, Groups: groups}
+	if reviewed, err := selfSubjectReview(clientset); err == nil && reviewed.Username != "" {
+		identity = reviewed
+	}
+
+	if existingToken, err := c.Cookie("sessionToken"); err == nil {
+		deleteSession(existingToken)
+		forgetPermissions(existingToken)
+	}
+	sessionToken := newSessionToken()
+	session := SessionData{
+		KubeconfigContent: kubeconfigContent,
+		Context:           oidcContext,
+		Username:          identity.Username,
+		Groups:            identity.Groups,
+		UID:               identity.UID,
+		OIDC:              tokens,
+		CreatedAt:         time.Now(),
+	}
+	renewSession(&session)
+	saveSession(sessionToken, session)
+	c.SetCookie("sessionToken", sessionToken, sessionCookieMaxAge(session), "/", "", false, true)
+	c.Redirect(http.StatusFound, "/dashboard")
+}
+
+// refreshOIDCSession swaps the session's ID token for a fresh one when it is
+// about to expire. The session is returned unchanged when no refresh is due.
+func refreshOIDCSession(sessionToken string, session SessionData) (SessionData, error) {
+	if !oidcRefreshDue(session) {
+		return session, nil
+	}
+	lock := oidcRefreshLock(sessionToken)
+	lock.Lock()
+	defer lock.Unlock()
+	// Another request may have refreshed while this one waited
+	session, exists := getSession(sessionToken)
+	if !exists {
+		return session, fmt.Errorf("the session has ended, please log in again")
+	}
+	if !oidcRefreshDue(session) {
+		return session, nil
+	}
+	if session.OIDC.RefreshToken == "" {
+		return session, fmt.Errorf("the OIDC session expired and cannot be refreshed, please log in again")
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {session.OIDC.RefreshToken},
+	}
+	tokens, _, err := oidcTokenRequest(form, "")
+	if err != nil {
+		return session, fmt.Errorf("error refreshing OIDC tokens: %v", err)
+	}
+	// Issuers may keep the refresh token the same
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = session.OIDC.RefreshToken
+	}
+	tokens.User = session.OIDC.User
+
+	session, _, err = updateSession(sessionToken, func(session *SessionData) error {
+		// Only the token changes, so contexts added to the session are kept
+		kubeconfig, err := clientcmd.Load([]byte(session.KubeconfigContent))
+		if err != nil {
+			return err
+		}
+		if authInfo, ok := kubeconfig.AuthInfos[tokens.User]; ok {
+			authInfo.Token = tokens.IDToken
+		}
+		content, err := clientcmd.Write(*kubeconfig)
+		if err != nil {
+			return err
+		}
+		session.KubeconfigContent = string(content)
+		session.OIDC = tokens
+		return nil
+	})
+	if err != nil {
+		return session, err
+	}
+	log.Printf("Refreshed OIDC tokens of %s", session.Username)
+	return session, nil
+}
+
+func oidcRefreshDue(session SessionData) bool {
+	return session.OIDC != nil && time.Until(session.OIDC.Expiry) <= oidcRefreshMargin
+}
+
+func oidcRefreshLock(sessionToken string) *sync.Mutex {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	lock, ok := oidcRefreshLocks[sessionToken]
+	if !ok {
+		lock = &sync.Mutex{}
+		oidcRefreshLocks[sessionToken] = lock
+	}
+	return lock
+}
+
+// oidcTokenRequest calls the token endpoint and verifies the ID token it
+// returns. nonce is checked when set, which refreshes do not do.
+func oidcTokenRequest(form url.Values, nonce string) (*oidcTokens, map[string]interface{}, error) {
+	p, err := oidcDiscover()
+	if err != nil {
+		return nil, nil, err
+	}
+	form.Set("client_id", os.Getenv(oidcClientIDEnv))
+	if secret := os.Getenv(oidcClientSecretEnv); secret != "" {
+		form.Set("client_secret", secret)
+	}
+	resp, err := oidcClient.PostForm(p.TokenEndpoint, form)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	var body oidcTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, nil, fmt.Errorf("unreadable token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("token endpoint answered %d: %s %s", resp.StatusCode, body.Error, body.Description)
+	}
+	if body.IDToken == "" {
+		return nil, nil, fmt.Errorf("the token response has no id_token")
+	}
+
+	claims, err := verifyIDToken(p, body.IDToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	if nonce != "" && claims["nonce"] != nonce {
+		return nil, nil, fmt.Errorf("the ID token nonce does not match the login")
+	}
+	exp, _ := claims["exp"].(float64)
+	return &oidcTokens{
+		IDToken:      body.IDToken,
+		RefreshToken: body.RefreshToken,
+		Expiry:       time.Unix(int64(exp), 0),
+	}, claims, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience and expiry of an ID
+// token and returns its claims. RS256 and ES256 signatures are supported.
+func verifyIDToken(p *oidcProvider, idToken string) (map[string]interface{}, error) {
+	header, claims, signature, err := parseJWT(idToken)
+	if err != nil {
+		return nil, err
+	}
+	key, err := oidcSigningKey(p, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signed := idToken[:strings.LastIndex(idToken, ".")]
+	digest := sha256.Sum256([]byte(signed))
+	switch header.Algorithm {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) != nil {
+			return nil, fmt.Errorf("invalid ID token signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return nil, fmt.Errorf("invalid ID token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return nil, fmt.Errorf("invalid ID token signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ID token algorithm %q", header.Algorithm)
+	}
+
+	if claims["iss"] != p.Issuer {
+		return nil, fmt.Errorf("the ID token was issued by %v, not %s", claims["iss"], p.Issuer)
+	}
+	if !audienceContains(claims["aud"], os.Getenv(oidcClientIDEnv)) {
+		return nil, fmt.Errorf("the ID token is not meant for this client")
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("the ID token has expired")
+	}
+	return claims, nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// parseJWT decodes a JWT without verifying it
+func parseJWT(token string) (jwtHeader, map[string]interface{}, []byte, error) {
+	var header jwtHeader
+	var claims map[string]interface{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, nil, nil, fmt.Errorf("malformed JWT")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return header, nil, nil, fmt.Errorf("malformed JWT header: %v", err)
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return header, nil, nil, fmt.Errorf("malformed JWT header: %v", err)
+	}
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return header, nil, nil, fmt.Errorf("malformed JWT claims: %v", err)
+	}
+	if err := json.Unmarshal(rawClaims, &claims); err != nil {
+		return header, nil, nil, fmt.Errorf("malformed JWT claims: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return header, nil, nil, fmt.Errorf("malformed JWT signature: %v", err)
+	}
+	return header, claims, signature, nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// oidcDiscover fetches and caches the issuer's discovery document
+func oidcDiscover() (*oidcProvider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if provider != nil {
+		return provider, nil
+	}
+
+	issuer := strings.TrimSuffix(os.Getenv(oidcIssuerEnv), "/")
+	var p oidcProvider
+	if err := getJSON(issuer+"/.well-known/openid-configuration", &p); err != nil {
+		return nil, err
+	}
+	if p.Issuer != issuer {
+		return nil, fmt.Errorf("the discovery document names issuer %s, not %s", p.Issuer, issuer)
+	}
+	provider = &p
+	return provider, nil
+}
+
+// oidcSigningKey returns the issuer key with the given ID, fetching the key
+// set again when the issuer has rotated to a key not seen yet. The key set is
+// fetched at most once per jwksRefreshInterval, so tokens with made-up key IDs
+// cannot make the dashboard hammer the issuer.
+func oidcSigningKey(p *oidcProvider, keyID string) (crypto.PublicKey, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if key, ok := jwks[keyID]; ok {
+		return key, nil
+	}
+	if time.Since(jwksFetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("the issuer has no signing key %q", keyID)
+	}
+	jwksFetchedAt = time.Now()
+
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+			Curve   string `json:"crv"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(p.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("error fetching the issuer's keys: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.KeyType {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if jwk.Curve != "P-256" || errX != nil || errY != nil {
+				continue
+			}
+			keys[jwk.KeyID] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	jwks = keys
+
+	key, ok := jwks[keyID]
+	if !ok {
+		return nil, fmt.Errorf("the issuer has no signing key %q", keyID)
+	}
+	return key, nil
+}
+
+// oidcKubeconfig builds the kubeconfig of an OIDC session, which sends the ID
+// token to the configured API server
+func oidcKubeconfig(tokens *oidcTokens) (string, error) {
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[oidcContext] = &clientcmdapi.Cluster{
+		Server:               os.Getenv(oidcClusterServerEnv),
+		CertificateAuthority: os.Getenv(oidcClusterCAFileEnv),
+	}
+	kubeconfig.AuthInfos[tokens.User] = &clientcmdapi.AuthInfo{Token: tokens.IDToken}
+	kubeconfig.Contexts[oidcContext] = &clientcmdapi.Context{Cluster: oidcContext, AuthInfo: tokens.User}
+	kubeconfig.CurrentContext = oidcContext
+	content, err := clientcmd.Write(*kubeconfig)
+	return string(content), err
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := oidcClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s answered %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func oidcScopes() string {
+	if scopes := strings.TrimSpace(os.Getenv(oidcScopesEnv)); scopes != "" {
+		return scopes
+	}
+	return defaultOIDCScopes
+}
+
+func oidcUsernameClaim() string {
+	if claim := strings.TrimSpace(os.Getenv(oidcUsernameClaimEnv)); claim != "" {
+		return claim
+	}
+	return defaultOIDCUsernameClaim
+}
+
+// randomString returns 32 random bytes, base64url encoded, as PKCE verifiers
+// and login states need
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// cleanupOIDCLogins forgets logins that were never completed and the refresh
+// locks of sessions that have ended
+func cleanupOIDCLogins() {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	for state, login := range oidcLogins {
+		if time.Now().After(login.expiresAt) {
+			delete(oidcLogins, state)
+		}
+	}
+	for sessionToken := range oidcRefreshLocks {
+		if _, exists := getSession(sessionToken); !exists {
+			delete(oidcRefreshLocks, sessionToken)
+		}
+	}
+}
@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testClientID = "dashboard"
+	testKeyID    = "key-1"
+)
+
+// mockIssuer is an OIDC provider serving discovery, its key set and a token
+// endpoint that enforces PKCE and rotates refresh tokens
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// codes holds the code challenge and nonce of each authorization code
+	codes         map[string][2]string
+	refreshTokens map[string]bool
+	refreshes     int
+	jwksFetches   int
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{key: key, codes: make(map[string][2]string), refreshTokens: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                issuer.URL,
+			AuthorizationEndpoint: issuer.URL + "/authorize",
+			TokenEndpoint:         issuer.URL + "/token",
+			JWKSURI:               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		issuer.jwksFetches++
+		issuer.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKeyID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+
+	t.Setenv(oidcIssuerEnv, issuer.URL)
+	t.Setenv(oidcClientIDEnv, testClientID)
+	t.Setenv(oidcRedirectURLEnv, "http://dashboard/oidc/callback")
+	resetOIDCCache()
+	t.Cleanup(resetOIDCCache)
+	return issuer
+}
+
+func resetOIDCCache() {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	provider = nil
+	jwks = make(map[string]crypto.PublicKey)
+	jwksFetchedAt = time.Time{}
+}
+
+// authorize plays the user logging in at the issuer and returns the code
+func (issuer *mockIssuer) authorize(query url.Values) string {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	code := randomString()
+	issuer.codes[code] = [2]string{query.Get("code_challenge"), query.Get("nonce")}
+	return code
+}
+
+func (issuer *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	fail := func(reason string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(oidcTokenResponse{Error: "invalid_grant", Description: reason})
+	}
+	var nonce string
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		login, ok := issuer.codes[r.PostFormValue("code")]
+		delete(issuer.codes, r.PostFormValue("code"))
+		if !ok {
+			fail("unknown code")
+			return
+		}
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(challenge[:]) != login[0] {
+			fail("code verifier does not match the challenge")
+			return
+		}
+		nonce = login[1]
+	case "refresh_token":
+		// Every refresh token works once
+		if !issuer.refreshTokens[r.PostFormValue("refresh_token")] {
+			fail("refresh token already used")
+			return
+		}
+		delete(issuer.refreshTokens, r.PostFormValue("refresh_token"))
+		issuer.refreshes++
+	default:
+		fail("unsupported grant")
+		return
+	}
+	claims := issuer.claims()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	refreshToken := randomString()
+	issuer.refreshTokens[refreshToken] = true
+	json.NewEncoder(w).Encode(oidcTokenResponse{IDToken: issuer.sign(testKeyID, claims), RefreshToken: refreshToken})
+}
+
+func (issuer *mockIssuer) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":    issuer.URL,
+		"aud":    testClientID,
+		"sub":    "1234",
+		"email":  "alice@example.com",
+		"groups": []string{"developers"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func (issuer *mockIssuer) sign(keyID string, claims map[string]interface{}) string {
+	return signJWT(issuer.key, keyID, claims)
+}
+
+func signJWT(key *rsa.PrivateKey, keyID string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": keyID})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	issuer := newMockIssuer(t)
+	p, err := oidcDiscover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := issuer.claims()
+		claims[name] = value
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "valid", token: issuer.sign(testKeyID, issuer.claims())},
+		{name: "audience list", token: issuer.sign(testKeyID, with("aud", []string{"other", testClientID}))},
+		{name: "bad signature", token: signJWT(otherKey, testKeyID, issuer.claims()), wantErr: "invalid ID token signature"},
+		{name: "tampered claims", token: tamper(issuer.sign(testKeyID, issuer.claims())), wantErr: "invalid ID token signature"},
+		{name: "unknown key", token: issuer.sign("key-2", issuer.claims()), wantErr: "no signing key"},
+		{name: "wrong issuer", token: issuer.sign(testKeyID, with("iss", "https://evil.example.com")), wantErr: "issued by"},
+		{name: "wrong audience", token: issuer.sign(testKeyID, with("aud", "other")), wantErr: "not meant for this client"},
+		{name: "expired", token: issuer.sign(testKeyID, with("exp", time.Now().Add(-time.Minute).Unix())), wantErr: "expired"},
+		{name: "no expiry", token: issuer.sign(testKeyID, with("exp", nil)), wantErr: "expired"},
+		{name: "unsigned", token: unsigned(issuer.claims()), wantErr: "unsupported ID token algorithm"},
+		{name: "malformed", token: "not.a.jwt", wantErr: "malformed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := verifyIDToken(p, test.token)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCSigningKeyRefetchLimit(t *testing.T) {
+	issuer := newMockIssuer(t)
+	p, err := oidcDiscover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches := func() int {
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+		return issuer.jwksFetches
+	}
+
+	if _, err := oidcSigningKey(p, testKeyID); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := oidcSigningKey(p, fmt.Sprintf("unknown-%d", i)); err == nil || !strings.Contains(err.Error(), "no signing key") {
+			t.Fatalf("got error %v for an unknown key", err)
+		}
+	}
+	if got := fetches(); got != 1 {
+		t.Fatalf("fetched the key set %d times, want once", got)
+	}
+
+	// Once the interval has passed an unknown key is looked up again
+	oidcMu.Lock()
+	jwksFetchedAt = time.Now().Add(-jwksRefreshInterval)
+	oidcMu.Unlock()
+	if _, err := oidcSigningKey(p, "unknown"); err == nil {
+		t.Fatal("found an unknown key")
+	}
+	if _, err := oidcSigningKey(p, testKeyID); err != nil {
+		t.Fatal(err)
+	}
+	if got := fetches(); got != 2 {
+		t.Errorf("fetched the key set %d times, want twice", got)
+	}
+}
+
+// tamper swaps the claims of a signed token for different ones
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(map[string]interface{}{"email": "mallory@example.com"})
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+}
+
+func unsigned(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": testKeyID})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func TestOIDCTokenRequestNonce(t *testing.T) {
+	issuer := newMockIssuer(t)
+	verifier := randomString()
+	challenge := sha256.Sum256([]byte(verifier))
+	code := issuer.authorize(url.Values{
+		"code_challenge": {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"nonce":          {"issued-nonce"},
+	})
+
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "code_verifier": {verifier}}
+	_, _, err := oidcTokenRequest(form, "expected-nonce")
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("got error %v, want a nonce mismatch", err)
+	}
+}
+
+// mockAPIServer answers the version and SelfSubjectReview requests of a login
+func mockAPIServer(t *testing.T, username string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/version":
+			fmt.Fprint(w, `{"major":"1","minor":"27","gitVersion":"v1.27.4"}`)
+		case "/apis/authentication.k8s.io/v1beta1/selfsubjectreviews":
+			fmt.Fprintf(w, `{"apiVersion":"authentication.k8s.io/v1beta1","kind":"SelfSubjectReview","status":{"userInfo":{"username":%q,"groups":["oidc:developers","system:authenticated"]}}}`, username)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func oidcRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/oidc/login", OIDCLogin)
+	router.GET("/oidc/callback", OIDCCallback)
+	return router
+}
+
+// startOIDCLogin starts a login and returns the authorization request the
+// browser is sent to and the state cookie it gets
+func startOIDCLogin(t *testing.T, router *gin.Engine) (url.Values, *http.Cookie) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login answered %d: %s", w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("login does not use PKCE: %s", location)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return query, cookie
+		}
+	}
+	t.Fatal("login set no state cookie")
+	return nil, nil
+}
+
+func callback(router *gin.Engine, query url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/oidc/callback?"+query.Encode(), nil)
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	return w
+}
+
+func TestOIDCLoginRoundTrip(t *testing.T) {
+	issuer := newMockIssuer(t)
+	t.Setenv(oidcClusterServerEnv, mockAPIServer(t, "oidc:alice@example.com").URL)
+	router := oidcRouter()
+
+	query, stateCookie := startOIDCLogin(t, router)
+	code := issuer.authorize(query)
+	w := callback(router, url.Values{"code": {code}, "state": {query.Get("state")}}, stateCookie)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("callback answered %d: %s", w.Code, w.Body.String())
+	}
+
+	var session SessionData
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "sessionToken" {
+			session, _ = getSession(cookie.Value)
+			defer deleteSession(cookie.Value)
+		}
+	}
+	if session.OIDC == nil {
+		t.Fatal("callback started no OIDC session")
+	}
+	// The username is the API server's, not the email claim
+	if session.Username != "oidc:alice@example.com" {
+		t.Errorf("session user is %q", session.Username)
+	}
+	if session.OIDC.RefreshToken == "" {
+		t.Error("session has no refresh token")
+	}
+
+	// A state is only good once
+	w = callback(router, url.Values{"code": {code}, "state": {query.Get("state")}}, stateCookie)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback answered %d", w.Code)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	issuer := newMockIssuer(t)
+	t.Setenv(oidcClusterServerEnv, mockAPIServer(t, "alice").URL)
+	router := oidcRouter()
+
+	t.Run("login started in another browser", func(t *testing.T) {
+		query, _ := startOIDCLogin(t, router)
+		code := issuer.authorize(query)
+		w := callback(router, url.Values{"code": {code}, "state": {query.Get("state")}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("callback without the state cookie answered %d", w.Code)
+		}
+	})
+	t.Run("state of another login", func(t *testing.T) {
+		query, _ := startOIDCLogin(t, router)
+		_, otherCookie := startOIDCLogin(t, router)
+		code := issuer.authorize(query)
+		w := callback(router, url.Values{"code": {code}, "state": {query.Get("state")}}, otherCookie)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("callback with another login's cookie answered %d", w.Code)
+		}
+	})
+	t.Run("wrong code verifier", func(t *testing.T) {
+		query, stateCookie := startOIDCLogin(t, router)
+		// The issuer saw a challenge the dashboard's verifier does not match
+		query.Set("code_challenge", "tampered")
+		code := issuer.authorize(query)
+		w := callback(router, url.Values{"code": {code}, "state": {query.Get("state")}}, stateCookie)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "code verifier") {
+			t.Errorf("callback answered %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestRefreshOIDCSession(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.mu.Lock()
+	issuer.refreshTokens["refresh-1"] = true
+	issuer.mu.Unlock()
+
+	tokens := &oidcTokens{IDToken: "old", RefreshToken: "refresh-1", Expiry: time.Now().Add(time.Minute), User: "alice"}
+	kubeconfig, err := oidcKubeconfig(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionToken := newSessionToken()
+	saveSession(sessionToken, SessionData{KubeconfigContent: kubeconfig, Context: oidcContext, OIDC: tokens, ExpiresAt: time.Now().Add(time.Hour)})
+	defer deleteSession(sessionToken)
+
+	// Concurrent requests, like a fan-out across clusters, all find the token
+	// due. The issuer rejects a reused refresh token, so only one may refresh.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, _ := getSession(sessionToken)
+			_, err := refreshOIDCSession(sessionToken, session)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("refresh failed: %v", err)
+		}
+	}
+	if issuer.refreshes != 1 {
+		t.Errorf("the issuer saw %d refreshes, want 1", issuer.refreshes)
+	}
+
+	session, _ := getSession(sessionToken)
+	if session.OIDC.IDToken == "old" || session.OIDC.RefreshToken == "refresh-1" {
+		t.Fatal("the session kept its old tokens")
+	}
+	if !strings.Contains(session.KubeconfigContent, session.OIDC.IDToken) {
+		t.Error("the kubeconfig does not use the new ID token")
+	}
+	if time.Until(session.OIDC.Expiry) <= oidcRefreshMargin {
+		t.Error("the new ID token is already due for a refresh")
+	}
+}
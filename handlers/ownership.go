@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// OwnershipNode is an object in a workload's ownership tree. Healthy is false
+// for an object that is failing or not ready; Message says why.
+type OwnershipNode struct {
+	Kind     string          `json:"kind"`
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	Healthy  bool            `json:"healthy"`
+	Message  string          `json:"message,omitempty"`
+	Age      string          `json:"age"`
+	Children []OwnershipNode `json:"children"`
+
+	createdAt time.Time
+}
+
+// GetDeploymentTree returns Deployment → ReplicaSets → Pods
+func GetDeploymentTree(c *gin.Context) {
+	ownershipTree(c, deploymentTree)
+}
+
+// GetStatefulSetTree returns StatefulSet → Pods → PersistentVolumeClaims
+func GetStatefulSetTree(c *gin.Context) {
+	ownershipTree(c, statefulSetTree)
+}
+
+// GetCronJobTree returns CronJob → Jobs → Pods
+func GetCronJobTree(c *gin.Context) {
+	ownershipTree(c, cronJobTree)
+}
+
+func ownershipTree(c *gin.Context, build func(clientset *kubernetes.Clientset, namespace, name string) (OwnershipNode, error)) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+
+	tree, err := build(clientset, c.Param("namespace"), c.Param("name"))
+	if apierrors.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, tree)
+}
+
+func deploymentTree(clientset *kubernetes.Clientset, namespace, name string) (OwnershipNode, error) {
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return OwnershipNode{}, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return OwnershipNode{}, err
+	}
+	listOptions := metav1.ListOptions{LabelSelector: selector.String()}
+	replicaSets, err := clientset.AppsV1().ReplicaSets(namespace).List(context.TODO(), listOptions)
+	if err != nil {
+		return OwnershipNode{}, err
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), listOptions)
+	if err != nil {
+		return OwnershipNode{}, err
+	}
+
+	resource := deploymentResource(*deployment)
+	root := OwnershipNode{
+		Kind:     "Deployment",
+		Name:     deployment.Name,
+		Status:   resource.Ready,
+		Healthy:  deployment.Status.ReadyReplicas >= replicasOrDefault(deployment.Spec.Replicas),
+		Age:      resource.Age,
+		Children: []OwnershipNode{},
+	}
+	for _, rs := range replicaSets.Items {
+		if !ownedBy(rs.ObjectMeta, deployment.UID) {
+			continue
+		}
+		root.Children = append(root.Children, replicaSetNode(rs, podsOwnedBy(pods.Items, rs.UID)))
+	}
+	sortNewestFirst(root.Children)
+	root.Message = unhealthyChildren(root.Children, "ReplicaSet")
+	return root, nil
+}
+
+func statefulSetTree(clientset *kubernetes.Clientset, namespace, name string) (OwnershipNode, error) {
+	statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return OwnershipNode{}, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(statefulSet.Spec.Selector)
+	if err != nil {
+		return OwnershipNode{}, err
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return OwnershipNode{}, err
+	}
+	claims, err := clientset.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return OwnershipNode{}, err
+	}
+	claimsByName := make(map[string]corev1.PersistentVolumeClaim, len(claims.Items))
+	for _, claim := range claims.Items {
+		claimsByName[claim.Name] = claim
+	}
+
+	resource := statefulSetResource(*statefulSet)
+	root := OwnershipNode{
+		Kind:     "StatefulSet",
+		Name:     statefulSet.Name,
+		Status:   resource.Ready,
+		Healthy:  statefulSet.Status.ReadyReplicas >= replicasOrDefault(statefulSet.Spec.Replicas),
+		Age:      resource.Age,
+		Children: []OwnershipNode{},
+	}
+	for _, pod := range podsOwnedBy(pods.Items, statefulSet.UID) {
+		node := podNode(pod)
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim == nil {
+				continue
+			}
+			node.Children = append(node.Children, claimNode(volume.PersistentVolumeClaim.ClaimName, claimsByName))
+		}
+		if message := unhealthyChildren(node.Children, "PersistentVolumeClaim"); message != "" {
+			node.Healthy = false
+			node.Message = joinMessages(node.Message, message)
+		}
+		root.Children = append(root.Children, node)
+	}
+	sortByName(root.Children)
+	root.Message = unhealthyChildren(root.Children, "Pod")
+	return root, nil
+}
+
+func cronJobTree(clientset *kubernetes.Clientset, namespace, name string) (OwnershipNode, error) {
+	cronJob, err := clientset.BatchV1().CronJobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return OwnershipNode{}, err
+	}
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return OwnershipNode{}, err
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return OwnershipNode{}, err
+	}
+
+	status := "Active"
+	if cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend {
+		status = "Suspended"
+	}
+	if cronJob.Status.LastScheduleTime != nil {
+		status += ", last scheduled " + formatDuration(time.Since(cronJob.Status.LastScheduleTime.Time)) + " ago"
+	}
+	root := OwnershipNode{
+		Kind:     "CronJob",
+		Name:     cronJob.Name,
+		Status:   status,
+		Healthy:  true,
+		Age:      formatDuration(time.Since(cronJob.CreationTimestamp.Time)),
+		Children: []OwnershipNode{},
+	}
+	for _, job := range jobs.Items {
+		if !ownedBy(job.ObjectMeta, cronJob.UID) {
+			continue
+		}
+		root.Children = append(root.Children, jobNode(job, podsOwnedBy(pods.Items, job.UID)))
+	}
+	sortNewestFirst(root.Children)
+	// Only the most recent run says whether the CronJob is currently healthy
+	if len(root.Children) > 0 && !root.Children[0].Healthy {
+		root.Healthy = false
+		root.Message = "latest Job " + root.Children[0].Name + " is failing"
+	}
+	return root, nil
+}
+
+func replicaSetNode(rs appsv1.ReplicaSet, pods []corev1.Pod) OwnershipNode {
+	desired := replicasOrDefault(rs.Spec.Replicas)
+	node := OwnershipNode{
+		Kind:      "ReplicaSet",
+		Name:      rs.Name,
+		Status:    fmt.Sprintf("%d/%d ready, revision %s", rs.Status.ReadyReplicas, desired, rs.Annotations["deployment.kubernetes.io/revision"]),
+		Healthy:   rs.Status.ReadyReplicas >= desired,
+		Age:       formatDuration(time.Since(rs.CreationTimestamp.Time)),
+		Children:  podNodes(pods),
+		createdAt: rs.CreationTimestamp.Time,
+	}
+	node.Message = unhealthyChildren(node.Children, "Pod")
+	return node
+}
+
+func jobNode(job batchv1.Job, pods []corev1.Pod) OwnershipNode {
+	completions := int32(1)
+	if job.Spec.Completions != nil {
+		completions = *job.Spec.Completions
+	}
+	node := OwnershipNode{
+		Kind:      "Job",
+		Name:      job.Name,
+		Status:    fmt.Sprintf("%d/%d succeeded, %d active, %d failed", job.Status.Succeeded, completions, job.Status.Active, job.Status.Failed),
+		Healthy:   true,
+		Age:       formatDuration(time.Since(job.CreationTimestamp.Time)),
+		Children:  podNodes(pods),
+		createdAt: job.CreationTimestamp.Time,
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			node.Healthy = false
+			node.Message = joinMessages(condition.Reason, condition.Message)
+		}
+	}
+	return node
+}
+
+func podNodes(pods []corev1.Pod) []OwnershipNode {
+	nodes := []OwnershipNode{}
+	for _, pod := range pods {
+		nodes = append(nodes, podNode(pod))
+	}
+	sortByName(nodes)
+	return nodes
+}
+
+// podNode reuses the pod list's status, so the tree explains a pod the same
+// way the pod view does
+func podNode(pod corev1.Pod) OwnershipNode {
+	resource := podResource(pod)
+	node := OwnershipNode{
+		Kind:     "Pod",
+		Name:     pod.Name,
+		Status:   resource.Status,
+		Healthy:  podHealthy(pod),
+		Age:      resource.Age,
+		Children: []OwnershipNode{},
+	}
+	if !node.Healthy {
+		node.Message = fmt.Sprintf("%s ready, %d restarts", resource.Ready, resource.Restarts)
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+				node.Message = joinMessages(condition.Reason, condition.Message)
+			}
+		}
+	}
+	return node
+}
+
+func claimNode(name string, claims map[string]corev1.PersistentVolumeClaim) OwnershipNode {
+	claim, ok := claims[name]
+	if !ok {
+		return OwnershipNode{Kind: "PersistentVolumeClaim", Name: name, Status: "Missing", Message: "claim does not exist", Children: []OwnershipNode{}}
+	}
+	node := OwnershipNode{
+		Kind:     "PersistentVolumeClaim",
+		Name:     claim.Name,
+		Status:   string(claim.Status.Phase),
+		Healthy:  claim.Status.Phase == corev1.ClaimBound,
+		Age:      formatDuration(time.Since(claim.CreationTimestamp.Time)),
+		Children: []OwnershipNode{},
+	}
+	if !node.Healthy {
+		node.Message = "claim is " + string(claim.Status.Phase)
+	}
+	return node
+}
+
+// podHealthy reports whether a pod finished successfully or is running with
+// every container ready
+func podHealthy(pod corev1.Pod) bool {
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return true
+	case corev1.PodRunning:
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady {
+				return condition.Status == corev1.ConditionTrue
+			}
+		}
+	}
+	return false
+}
+
+func ownedBy(meta metav1.ObjectMeta, uid types.UID) bool {
+	for _, owner := range meta.OwnerReferences {
+		if owner.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func podsOwnedBy(pods []corev1.Pod, uid types.UID) []corev1.Pod {
+	var owned []corev1.Pod
+	for _, pod := range pods {
+		if ownedBy(pod.ObjectMeta, uid) {
+			owned = append(owned, pod)
+		}
+	}
+	return owned
+}
+
+// unhealthyChildren summarizes how many children are unhealthy, e.g.
+// "2 of 3 Pods unhealthy", or returns "" when all are healthy
+func unhealthyChildren(children []OwnershipNode, kind string) string {
+	unhealthy := 0
+	for _, child := range children {
+		if !child.Healthy {
+			unhealthy++
+		}
+	}
+	if unhealthy == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d of %d %ss unhealthy", unhealthy, len(children), kind)
+}
+
+func joinMessages(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + ": " + b
+}
+
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+func sortByName(nodes []OwnershipNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+}
+
+// sortNewestFirst puts the latest ReplicaSet or Job run first
+func sortNewestFirst(nodes []OwnershipNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].createdAt.After(nodes[j].createdAt)
+	})
+}
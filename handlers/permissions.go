@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// permissionsTTL is how long a session's permissions are cached before they
+// are asked for again, so RBAC changes show up without logging out
+const permissionsTTL = 5 * time.Minute
+
+// permissionCheck is the access an action needs. Cluster checks are made
+// outside any namespace.
+type permissionCheck struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	Cluster     bool
+}
+
+func (p permissionCheck) String() string {
+	resource := p.Resource
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	return p.Verb + " " + resource
+}
+
+// dashboardActions are the actions the frontend offers and the access each
+// one needs
+var dashboardActions = map[string]permissionCheck{
+	"deployments.restart":             {Verb: "update", Group: "apps", Resource: "deployments"},
+	"statefulsets.restart":            {Verb: "update", Group: "apps", Resource: "statefulsets"},
+	"daemonsets.restart":              {Verb: "update", Group: "apps", Resource: "daemonsets"},
+	"pods.delete":                     {Verb: "delete", Resource: "pods"},
+	"horizontalpodautoscalers.update": {Verb: "update", Group: "autoscaling", Resource: "horizontalpodautoscalers"},
+	"secrets.reveal":                  {Verb: "get", Resource: "secrets"},
+	"nodes.cordon":                    {Verb: "patch", Resource: "nodes", Cluster: true},
+	"nodes.drain":                     {Verb: "create", Resource: "pods", Subresource: "eviction", Cluster: true},
+}
+
+// PermissionRule is one rule of a SelfSubjectRulesReview
+type PermissionRule struct {
+	Verbs         []string `json:"verbs"`
+	APIGroups     []string `json:"apiGroups"`
+	Resources     []string `json:"resources"`
+	ResourceNames []string `json:"resourceNames,omitempty"`
+}
+
+// sessionPermissions caches what a session may do in one namespace. rules
+// come from a SelfSubjectRulesReview; reviews holds every answered check.
+type sessionPermissions struct {
+	rules      []authorizationv1.ResourceRule
+	incomplete bool
+	reviews    map[string]bool
+	expiresAt  time.Time
+}
+
+var (
+	permissionsMu sync.Mutex
+	// permissionsCache is keyed by session token and then namespace
+	permissionsCache = make(map[string]map[string]*sessionPermissions)
+)
+
+// GetPermissions returns which dashboard actions the session may perform in
+// a namespace, with the RBAC rules behind them, so the frontend can disable
+// what would be refused. "_all" checks access across all namespaces.
+func GetPermissions(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	namespace := c.Param("namespace")
+	if namespace == allNamespacesParam {
+		namespace = metav1.NamespaceAll
+	}
+
+	actions := make(map[string]bool, len(dashboardActions))
+	for action, check := range dashboardActions {
+		allowed, err := sessionAllowed(clientset, sessionToken, namespace, check)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("Response status: %d", http.StatusInternalServerError)
+			return
+		}
+		actions[action] = allowed
+	}
+
+	rules := []PermissionRule{}
+	incomplete := false
+	if namespace != metav1.NamespaceAll {
+		permissions, err := namespacePermissions(clientset, sessionToken, namespace)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("Response status: %d", http.StatusInternalServerError)
+			return
+		}
+		for _, rule := range permissions.rules {
+			rules = append(rules, PermissionRule{
+				Verbs:         rule.Verbs,
+				APIGroups:     rule.APIGroups,
+				Resources:     rule.Resources,
+				ResourceNames: rule.ResourceNames,
+			})
+		}
+		incomplete = permissions.incomplete
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"namespace":  c.Param("namespace"),
+		"actions":    actions,
+		"rules":      rules,
+		"incomplete": incomplete,
+	})
+}
+
+// requirePermission answers 403 and returns false when the session may not
+// perform action in namespace. If the check itself fails the action goes
+// ahead: the handlers using it make a single API call right away, which the
+// API server authorizes anyway, and any refusal reaches the user in the
+// response.
+func requirePermission(c *gin.Context, clientset *kubernetes.Clientset, sessionToken, namespace, action string) bool {
+	return checkPermission(c, clientset, sessionToken, namespace, action, false)
+}
+
+// requireJobPermission is requirePermission for handlers that start a
+// background job. A job runs many calls long after the response, so when the
+// check fails it answers 503 instead of starting work the user may not be
+// allowed to do.
+func requireJobPermission(c *gin.Context, clientset *kubernetes.Clientset, sessionToken, namespace, action string) bool {
+	return checkPermission(c, clientset, sessionToken, namespace, action, true)
+}
+
+func checkPermission(c *gin.Context, clientset *kubernetes.Clientset, sessionToken, namespace, action string, failClosed bool) bool {
+	check := dashboardActions[action]
+	allowed, err := sessionAllowed(clientset, sessionToken, namespace, check)
+	if err != nil {
+		if failClosed {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("Could not check whether you may %s: %v", check, err)})
+			log.Printf("Response status: %d", http.StatusServiceUnavailable)
+			return false
+		}
+		log.Printf("Permission check for %s failed, leaving it to the API server: %v", action, err)
+		return true
+	}
+	if !allowed {
+		where := "in namespace " + namespace
+		if check.Cluster || namespace == metav1.NamespaceAll {
+			where = "in this cluster"
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("You are not allowed to %s %s", check, where)})
+		return false
+	}
+	return true
+}
+
+// sessionAllowed answers a check from the session's cached rules when they
+// are conclusive, and otherwise with a SelfSubjectAccessReview
+func sessionAllowed(clientset *kubernetes.Clientset, sessionToken, namespace string, check permissionCheck) (bool, error) {
+	if check.Cluster {
+		namespace = metav1.NamespaceAll
+	}
+	permissions, err := namespacePermissions(clientset, sessionToken, namespace)
+	if err != nil {
+		return false, err
+	}
+
+	key := check.String()
+	permissionsMu.Lock()
+	allowed, ok := permissions.reviews[key]
+	permissionsMu.Unlock()
+	if ok {
+		return allowed, nil
+	}
+
+	if rulesAllow(permissions.rules, check) {
+		allowed = true
+	} else if namespace != metav1.NamespaceAll && !permissions.incomplete {
+		allowed = false
+	} else {
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        check.Verb,
+					Group:       check.Group,
+					Resource:    check.Resource,
+					Subresource: check.Subresource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return false, err
+		}
+		allowed = review.Status.Allowed
+	}
+
+	permissionsMu.Lock()
+	permissions.reviews[key] = allowed
+	permissionsMu.Unlock()
+	return allowed, nil
+}
+
+// namespacePermissions returns the session's cached permissions for
+// namespace, running a SelfSubjectRulesReview when there are none yet.
+// Rules reviews need a namespace, so all namespaces only caches reviews.
+func namespacePermissions(clientset *kubernetes.Clientset, sessionToken, namespace string) (*sessionPermissions, error) {
+	permissionsMu.Lock()
+	permissions, ok := permissionsCache[sessionToken][namespace]
+	permissionsMu.Unlock()
+	if ok && time.Now().Before(permissions.expiresAt) {
+		return permissions, nil
+	}
+
+	permissions = &sessionPermissions{
+		reviews:   make(map[string]bool),
+		expiresAt: time.Now().Add(permissionsTTL),
+	}
+	if namespace != metav1.NamespaceAll {
+		review, err := clientset.AuthorizationV1().SelfSubjectRulesReviews().Create(context.TODO(), &authorizationv1.SelfSubjectRulesReview{
+			Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		permissions.rules = review.Status.ResourceRules
+		permissions.incomplete = review.Status.Incomplete
+	}
+
+	permissionsMu.Lock()
+	defer permissionsMu.Unlock()
+	if permissionsCache[sessionToken] == nil {
+		permissionsCache[sessionToken] = make(map[string]*sessionPermissions)
+	}
+	permissionsCache[sessionToken][namespace] = permissions
+	return permissions, nil
+}
+
+// rulesAllow reports whether a rule grants check on every object of its
+// resource. Rules limited to resource names do not.
+func rulesAllow(rules []authorizationv1.ResourceRule, check permissionCheck) bool {
+	resource := check.Resource
+	if check.Subresource != "" {
+		resource += "/" + check.Subresource
+	}
+	for _, rule := range rules {
+		if len(rule.ResourceNames) > 0 {
+			continue
+		}
+		if matchesRule(rule.Verbs, check.Verb) && matchesRule(rule.APIGroups, check.Group) && matchesRule(rule.Resources, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesRule(values []string, value string) bool {
+	return containsString(values, "*") || containsString(values, value)
+}
+
+// forgetPermissions drops a session's cached permissions
+func forgetPermissions(sessionToken string) {
+	permissionsMu.Lock()
+	defer permissionsMu.Unlock()
+	delete(permissionsCache, sessionToken)
+}
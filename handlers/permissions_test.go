@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestCheckPermission(t *testing.T) {
+	tests := []struct {
+		name         string
+		reviewStatus int
+		allowed      bool
+		failClosed   bool
+		want         bool
+		wantStatus   int
+	}{
+		{name: "allowed", reviewStatus: http.StatusCreated, allowed: true, want: true, wantStatus: http.StatusOK},
+		{name: "denied", reviewStatus: http.StatusCreated, wantStatus: http.StatusForbidden},
+		{name: "check fails open", reviewStatus: http.StatusInternalServerError, want: true, wantStatus: http.StatusOK},
+		{name: "check fails closed for jobs", reviewStatus: http.StatusInternalServerError, failClosed: true, wantStatus: http.StatusServiceUnavailable},
+		{name: "denied for jobs", reviewStatus: http.StatusCreated, failClosed: true, wantStatus: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Path != "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews" {
+					http.NotFound(w, r)
+					return
+				}
+				w.WriteHeader(test.reviewStatus)
+				if test.reviewStatus != http.StatusCreated {
+					json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Message: "authorizer unavailable", Code: int32(test.reviewStatus)})
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"apiVersion": "authorization.k8s.io/v1",
+					"kind":       "SelfSubjectAccessReview",
+					"status":     map[string]bool{"allowed": test.allowed},
+				})
+			}))
+			t.Cleanup(server.Close)
+			clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL, QPS: 1000, Burst: 1000})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessionToken := "test-" + t.Name()
+			t.Cleanup(func() { forgetPermissions(sessionToken) })
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			require := requirePermission
+			if test.failClosed {
+				require = requireJobPermission
+			}
+			if got := require(c, clientset, sessionToken, metav1.NamespaceAll, "nodes.drain"); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			if w.Code != test.wantStatus {
+				t.Errorf("answered %d, want %d: %s", w.Code, test.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
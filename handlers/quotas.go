@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// quotaWarningPercentEnv sets how full a quota must be before it is flagged
+const quotaWarningPercentEnv = "QUOTA_WARNING_PERCENT"
+
+const defaultQuotaWarningPercent = 80
+
+// QuotaResource is one resource limited by a ResourceQuota. Warning is set
+// once Percent reaches QUOTA_WARNING_PERCENT.
+type QuotaResource struct {
+	Resource string `json:"resource"`
+	Used     string `json:"used"`
+	Hard     string `json:"hard"`
+	Percent  int64  `json:"percent"`
+	Warning  bool   `json:"warning"`
+}
+
+type QuotaUsage struct {
+	Name         string            `json:"name"`
+	Namespace    string            `json:"namespace"`
+	Scopes       []string          `json:"scopes,omitempty"`
+	Resources    []QuotaResource   `json:"resources"`
+	Warning      bool              `json:"warning"`
+	Age          string            `json:"age"`
+	Labels       map[string]string `json:"labels"`
+	ResourceType string            `json:"resourceType"`
+
+	createdAt time.Time
+}
+
+func (r QuotaUsage) sortKey() sortKey {
+	return sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt}
+}
+
+// LimitRangeLimit is one limit of a LimitRange for one resource, e.g. the
+// default memory request of a Container
+type LimitRangeLimit struct {
+	Type                 string `json:"type"`
+	Resource             string `json:"resource"`
+	Min                  string `json:"min,omitempty"`
+	Max                  string `json:"max,omitempty"`
+	Default              string `json:"default,omitempty"`
+	DefaultRequest       string `json:"defaultRequest,omitempty"`
+	MaxLimitRequestRatio string `json:"maxLimitRequestRatio,omitempty"`
+}
+
+type LimitRangeResource struct {
+	Name         string            `json:"name"`
+	Namespace    string            `json:"namespace"`
+	Limits       []LimitRangeLimit `json:"limits"`
+	Age          string            `json:"age"`
+	Labels       map[string]string `json:"labels"`
+	ResourceType string            `json:"resourceType"`
+
+	createdAt time.Time
+}
+
+func (r LimitRangeResource) sortKey() sortKey {
+	return sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt}
+}
+
+// quotaExceededError is returned when an action would take more than a
+// ResourceQuota has left, so handlers can answer 409 instead of failing later
+type quotaExceededError struct {
+	problems []string
+}
+
+func (e *quotaExceededError) Error() string {
+	return "not enough quota: " + strings.Join(e.problems, "; ")
+}
+
+// GetResourceQuotas lists ResourceQuotas with hard vs used for every resource
+func GetResourceQuotas(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	request, ok := parseListRequest(c, "age")
+	if !ok {
+		return
+	}
+
+	respondList(c, clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[QuotaUsage], error) {
+		quotas, err := clientset.CoreV1().ResourceQuotas(namespace).List(context.TODO(), opts)
+		if err != nil {
+			return namespacePage[QuotaUsage]{}, err
+		}
+		page := namespacePage[QuotaUsage]{meta: quotas.ListMeta}
+		for _, quota := range quotas.Items {
+			page.items = append(page.items, quotaUsage(quota))
+		}
+		return page, nil
+	})
+}
+
+// GetLimitRanges lists LimitRanges with their min, max and default values
+func GetLimitRanges(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	request, ok := parseListRequest(c, "age")
+	if !ok {
+		return
+	}
+
+	respondList(c, clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[LimitRangeResource], error) {
+		limitRanges, err := clientset.CoreV1().LimitRanges(namespace).List(context.TODO(), opts)
+		if err != nil {
+			return namespacePage[LimitRangeResource]{}, err
+		}
+		page := namespacePage[LimitRangeResource]{meta: limitRanges.ListMeta}
+		for _, limitRange := range limitRanges.Items {
+			page.items = append(page.items, limitRangeResource(limitRange))
+		}
+		return page, nil
+	})
+}
+
+// quotaUsage compares what a ResourceQuota allows with what is used
+func quotaUsage(quota corev1.ResourceQuota) QuotaUsage {
+	threshold := quotaWarningPercent()
+	usage := QuotaUsage{
+		Name:         quota.Name,
+		Namespace:    quota.Namespace,
+		Resources:    []QuotaResource{},
+		Age:          formatDuration(time.Since(quota.CreationTimestamp.Time)),
+		Labels:       quota.Labels,
+		ResourceType: "ResourceQuota",
+		createdAt:    quota.CreationTimestamp.Time,
+	}
+	for _, scope := range quota.Spec.Scopes {
+		usage.Scopes = append(usage.Scopes, string(scope))
+	}
+	for name, hard := range quota.Status.Hard {
+		used := quota.Status.Used[name]
+		item := QuotaResource{
+			Resource: string(name),
+			Used:     used.String(),
+			Hard:     hard.String(),
+			Percent:  percentOf(used.MilliValue(), hard.MilliValue()),
+		}
+		item.Warning = item.Percent >= threshold
+		usage.Warning = usage.Warning || item.Warning
+		usage.Resources = append(usage.Resources, item)
+	}
+	sort.Slice(usage.Resources, func(i, j int) bool {
+		return usage.Resources[i].Resource < usage.Resources[j].Resource
+	})
+	return usage
+}
+
+func quotaWarningPercent() int64 {
+	percent, err := strconv.ParseInt(os.Getenv(quotaWarningPercentEnv), 10, 64)
+	if err != nil || percent <= 0 {
+		return defaultQuotaWarningPercent
+	}
+	return percent
+}
+
+// limitRangeResource computes the dashboard's columns for a single LimitRange
+func limitRangeResource(limitRange corev1.LimitRange) LimitRangeResource {
+	limits := []LimitRangeLimit{}
+	for _, item := range limitRange.Spec.Limits {
+		names := make(map[corev1.ResourceName]bool)
+		for _, list := range []corev1.ResourceList{item.Min, item.Max, item.Default, item.DefaultRequest, item.MaxLimitRequestRatio} {
+			for name := range list {
+				names[name] = true
+			}
+		}
+		for name := range names {
+			limits = append(limits, LimitRangeLimit{
+				Type:                 string(item.Type),
+				Resource:             string(name),
+				Min:                  quantityString(item.Min, name),
+				Max:                  quantityString(item.Max, name),
+				Default:              quantityString(item.Default, name),
+				DefaultRequest:       quantityString(item.DefaultRequest, name),
+				MaxLimitRequestRatio: quantityString(item.MaxLimitRequestRatio, name),
+			})
+		}
+	}
+	sort.SliceStable(limits, func(i, j int) bool {
+		if limits[i].Type != limits[j].Type {
+			return limits[i].Type < limits[j].Type
+		}
+		return limits[i].Resource < limits[j].Resource
+	})
+
+	return LimitRangeResource{
+		Name:         limitRange.Name,
+		Namespace:    limitRange.Namespace,
+		Limits:       limits,
+		Age:          formatDuration(time.Since(limitRange.CreationTimestamp.Time)),
+		Labels:       limitRange.Labels,
+		ResourceType: "LimitRange",
+		createdAt:    limitRange.CreationTimestamp.Time,
+	}
+}
+
+func quantityString(list corev1.ResourceList, name corev1.ResourceName) string {
+	if quantity, ok := list[name]; ok {
+		return quantity.String()
+	}
+	return ""
+}
+
+// deploymentSurge is how many extra pods a rolling update of d runs at once
+func deploymentSurge(d appsv1.Deployment) (int64, error) {
+	if d.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		return 0, nil
+	}
+	maxSurge := intstr.FromString("25%")
+	if d.Spec.Strategy.RollingUpdate != nil && d.Spec.Strategy.RollingUpdate.MaxSurge != nil {
+		maxSurge = *d.Spec.Strategy.RollingUpdate.MaxSurge
+	}
+	surge, err := intstr.GetScaledValueFromIntOrPercent(&maxSurge, int(replicasOrDefault(d.Spec.Replicas)), true)
+	return int64(surge), err
+}
+
+// checkQuotaHeadroom returns a *quotaExceededError when starting pods more
+// copies of template would exceed a ResourceQuota in namespace. Containers
+// without requests or limits get the LimitRange defaults, as admission would
+// give them. Quotas with scopes are skipped, since whether they apply depends
+// on the pods.
+func checkQuotaHeadroom(clientset *kubernetes.Clientset, namespace string, template corev1.PodTemplateSpec, pods int64) error {
+	if pods <= 0 {
+		return nil
+	}
+	quotas, err := clientset.CoreV1().ResourceQuotas(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	if len(quotas.Items) == 0 {
+		return nil
+	}
+	limitRanges, err := clientset.CoreV1().LimitRanges(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	pod := corev1.Pod{Spec: *template.Spec.DeepCopy()}
+	applyLimitRangeDefaults(&pod.Spec, limitRanges.Items)
+	requests, limits := podRequestsAndLimits(pod)
+
+	needed := corev1.ResourceList{
+		corev1.ResourcePods:               *resource.NewQuantity(pods, resource.DecimalSI),
+		corev1.ResourceName("count/pods"): *resource.NewQuantity(pods, resource.DecimalSI),
+	}
+	for name, quantity := range requests {
+		scaled := *resource.NewMilliQuantity(quantity.MilliValue()*pods, quantity.Format)
+		needed[corev1.ResourceName("requests."+string(name))] = scaled
+		// The bare names cpu and memory in a quota also mean requests
+		needed[name] = scaled
+	}
+	for name, quantity := range limits {
+		needed[corev1.ResourceName("limits."+string(name))] = *resource.NewMilliQuantity(quantity.MilliValue()*pods, quantity.Format)
+	}
+
+	var problems []string
+	for _, quota := range quotas.Items {
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			continue
+		}
+		for name, hard := range quota.Status.Hard {
+			need, ok := needed[name]
+			if !ok {
+				continue
+			}
+			total := quota.Status.Used[name].DeepCopy()
+			total.Add(need)
+			if total.Cmp(hard) > 0 {
+				left := hard.DeepCopy()
+				left.Sub(quota.Status.Used[name])
+				problems = append(problems, fmt.Sprintf("%s %s needs %s but only %s of %s is left", quota.Name, name, need.String(), left.String(), hard.String()))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return &quotaExceededError{problems: problems}
+	}
+	return nil
+}
+
+// applyLimitRangeDefaults fills in missing container requests and limits
+// from the Container defaults of the namespace's LimitRanges
+func applyLimitRangeDefaults(spec *corev1.PodSpec, limitRanges []corev1.LimitRange) {
+	for _, limitRange := range limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+			for i := range spec.Containers {
+				resources := &spec.Containers[i].Resources
+				if resources.Limits == nil {
+					resources.Limits = corev1.ResourceList{}
+				}
+				if resources.Requests == nil {
+					resources.Requests = corev1.ResourceList{}
+				}
+				for name, quantity := range item.Default {
+					if _, ok := resources.Limits[name]; !ok {
+						resources.Limits[name] = quantity.DeepCopy()
+					}
+				}
+				for name, quantity := range item.DefaultRequest {
+					if _, ok := resources.Requests[name]; !ok {
+						resources.Requests[name] = quantity.DeepCopy()
+					}
+				}
+				// Without a default request the request defaults to the limit
+				for name, quantity := range resources.Limits {
+					if _, ok := resources.Requests[name]; !ok {
+						resources.Requests[name] = quantity.DeepCopy()
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WorkloadRef is a workload that uses a ConfigMap or Secret. Via says how,
+// e.g. "volume:config", "envFrom" or "env:DB_PASSWORD".
+type WorkloadRef struct {
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Via       []string `json:"via"`
+}
+
+// workload is a controller with a pod template and the ConfigMaps and Secrets
+// that template references, keyed by name
+type workload struct {
+	Kind       string
+	Namespace  string
+	Name       string
+	ConfigMaps map[string][]string
+	Secrets    map[string][]string
+}
+
+// listWorkloads returns every Deployment, StatefulSet, DaemonSet and CronJob in
+// namespace with what their pod templates reference
+func listWorkloads(clientset *kubernetes.Clientset, namespace string) ([]workload, error) {
+	var workloads []workload
+	add := func(kind, ns, name string, spec corev1.PodSpec) {
+		configMaps, secrets := podSpecReferences(spec)
+		workloads = append(workloads, workload{Kind: kind, Namespace: ns, Name: name, ConfigMaps: configMaps, Secrets: secrets})
+	}
+
+	deployments, err := clientset.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deployments.Items {
+		add("Deployment", d.Namespace, d.Name, d.Spec.Template.Spec)
+	}
+
+	statefulSets, err := clientset.AppsV1().StatefulSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, ss := range statefulSets.Items {
+		add("StatefulSet", ss.Namespace, ss.Name, ss.Spec.Template.Spec)
+	}
+
+	daemonSets, err := clientset.AppsV1().DaemonSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, ds := range daemonSets.Items {
+		add("DaemonSet", ds.Namespace, ds.Name, ds.Spec.Template.Spec)
+	}
+
+	cronJobs, err := clientset.BatchV1().CronJobs(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, cj := range cronJobs.Items {
+		add("CronJob", cj.Namespace, cj.Name, cj.Spec.JobTemplate.Spec.Template.Spec)
+	}
+
+	return workloads, nil
+}
+
+// podSpecReferences finds the ConfigMaps and Secrets a pod spec uses through
+// volumes, projected volumes, env, envFrom and image pull secrets
+func podSpecReferences(spec corev1.PodSpec) (map[string][]string, map[string][]string) {
+	configMaps := make(map[string][]string)
+	secrets := make(map[string][]string)
+	addRef := func(refs map[string][]string, name, via string) {
+		if name != "" && !containsString(refs[name], via) {
+			refs[name] = append(refs[name], via)
+		}
+	}
+
+	for _, volume := range spec.Volumes {
+		via := "volume:" + volume.Name
+		if volume.ConfigMap != nil {
+			addRef(configMaps, volume.ConfigMap.Name, via)
+		}
+		if volume.Secret != nil {
+			addRef(secrets, volume.Secret.SecretName, via)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					addRef(configMaps, source.ConfigMap.Name, via)
+				}
+				if source.Secret != nil {
+					addRef(secrets, source.Secret.Name, via)
+				}
+			}
+		}
+	}
+
+	var containers []corev1.Container
+	containers = append(containers, spec.InitContainers...)
+	containers = append(containers, spec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				addRef(configMaps, envFrom.ConfigMapRef.Name, "envFrom")
+			}
+			if envFrom.SecretRef != nil {
+				addRef(secrets, envFrom.SecretRef.Name, "envFrom")
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				addRef(configMaps, ref.Name, "env:"+env.Name)
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				addRef(secrets, ref.Name, "env:"+env.Name)
+			}
+		}
+	}
+
+	for _, pullSecret := range spec.ImagePullSecrets {
+		addRef(secrets, pullSecret.Name, "imagePullSecret")
+	}
+
+	return configMaps, secrets
+}
+
+// workloadsReferencing returns the workloads whose references include name.
+// refs picks ConfigMaps or Secrets out of a workload.
+func workloadsReferencing(workloads []workload, namespace, name string, refs func(w workload) map[string][]string) []WorkloadRef {
+	result := []WorkloadRef{}
+	for _, w := range workloads {
+		if w.Namespace != namespace {
+			continue
+		}
+		if via, ok := refs(w)[name]; ok {
+			result = append(result, WorkloadRef{Kind: w.Kind, Namespace: w.Namespace, Name: w.Name, Via: via})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func configMapRefs(w workload) map[string][]string {
+	return w.ConfigMaps
+}
+
+func secretRefs(w workload) map[string][]string {
+	return w.Secrets
+}
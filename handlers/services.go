@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type ServiceResource struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace"`
+	Type              string            `json:"type"`
+	ClusterIP         string            `json:"clusterIP"`
+	ExternalIPs       []string          `json:"externalIPs,omitempty"`
+	Ports             []string          `json:"ports"`
+	Selector          map[string]string `json:"selector"`
+	ReadyEndpoints    int               `json:"readyEndpoints"`
+	NotReadyEndpoints int               `json:"notReadyEndpoints"`
+	NoReadyBackends   bool              `json:"noReadyBackends"`
+	Age               string            `json:"age"`
+	Labels            map[string]string `json:"labels"`
+	ResourceType      string            `json:"resourceType"`
+
+	createdAt time.Time
+}
+
+func (r ServiceResource) sortKey() sortKey {
+	return sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt}
+}
+
+// ServiceEndpoint is one address of an EndpointSlice, mapped back to its pod
+type ServiceEndpoint struct {
+	Address     string `json:"address"`
+	Ready       bool   `json:"ready"`
+	Terminating bool   `json:"terminating"`
+	Pod         string `json:"pod,omitempty"`
+	Node        string `json:"node,omitempty"`
+	Zone        string `json:"zone,omitempty"`
+}
+
+type ServiceDetail struct {
+	ServiceResource
+	Endpoints []ServiceEndpoint `json:"endpoints"`
+}
+
+type IngressPath struct {
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	PathType string `json:"pathType,omitempty"`
+	Service  string `json:"service,omitempty"`
+	Port     string `json:"port,omitempty"`
+	Resource string `json:"resource,omitempty"`
+	// BackendReady is false when the backend service has no ready endpoints.
+	// It is only filled in by the detail endpoint.
+	BackendReady *bool `json:"backendReady,omitempty"`
+}
+
+type IngressTLS struct {
+	Hosts      []string `json:"hosts"`
+	SecretName string   `json:"secretName"`
+}
+
+type IngressResource struct {
+	Name         string            `json:"name"`
+	Namespace    string            `json:"namespace"`
+	Class        string            `json:"class,omitempty"`
+	Hosts        []string          `json:"hosts"`
+	Paths        []IngressPath     `json:"paths"`
+	TLS          []IngressTLS      `json:"tls"`
+	Addresses    []string          `json:"addresses"`
+	Age          string            `json:"age"`
+	Labels       map[string]string `json:"labels"`
+	ResourceType string            `json:"resourceType"`
+
+	createdAt time.Time
+}
+
+func (r IngressResource) sortKey() sortKey {
+	return sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt}
+}
+
+// GetServices lists Services with their ports and how many ready endpoints
+// back them, flagging those whose selector matches no ready pods
+func GetServices(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	request, ok := parseListRequest(c, "age")
+	if !ok {
+		return
+	}
+
+	respondList(c, clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[ServiceResource], error) {
+		services, err := clientset.CoreV1().Services(namespace).List(context.TODO(), opts)
+		if err != nil {
+			return namespacePage[ServiceResource]{}, err
+		}
+		slices, err := clientset.DiscoveryV1().EndpointSlices(namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return namespacePage[ServiceResource]{}, err
+		}
+		slicesByService := make(map[string][]discoveryv1.EndpointSlice)
+		for _, slice := range slices.Items {
+			key := slice.Namespace + "/" + slice.Labels[discoveryv1.LabelServiceName]
+			slicesByService[key] = append(slicesByService[key], slice)
+		}
+
+		page := namespacePage[ServiceResource]{meta: services.ListMeta}
+		for _, svc := range services.Items {
+			page.items = append(page.items, serviceResource(svc, slicesByService[svc.Namespace+"/"+svc.Name]))
+		}
+		return page, nil
+	})
+}
+
+// GetService returns a Service with every endpoint behind it, ready or not,
+// and the pod each endpoint belongs to
+func GetService(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	namespace := c.Param("namespace")
+	serviceName := c.Param("name")
+
+	svc, err := clientset.CoreV1().Services(namespace).Get(context.TODO(), serviceName, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+	slices, err := serviceEndpointSlices(clientset, namespace, serviceName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	detail := ServiceDetail{
+		ServiceResource: serviceResource(*svc, slices),
+		Endpoints:       []ServiceEndpoint{},
+	}
+	// A dual-stack pod is one row with the addresses of both families
+	rows := make(map[string]int)
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			key := endpointKey(slice, endpoint)
+			if i, ok := rows[key]; ok {
+				row := &detail.Endpoints[i]
+				row.Address += "," + strings.Join(endpoint.Addresses, ",")
+				row.Ready = row.Ready || endpointReady(endpoint)
+				continue
+			}
+			item := ServiceEndpoint{
+				Address:     strings.Join(endpoint.Addresses, ","),
+				Ready:       endpointReady(endpoint),
+				Terminating: endpoint.Conditions.Terminating != nil && *endpoint.Conditions.Terminating,
+			}
+			if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+				item.Pod = endpoint.TargetRef.Name
+			}
+			if endpoint.NodeName != nil {
+				item.Node = *endpoint.NodeName
+			}
+			if endpoint.Zone != nil {
+				item.Zone = *endpoint.Zone
+			}
+			rows[key] = len(detail.Endpoints)
+			detail.Endpoints = append(detail.Endpoints, item)
+		}
+	}
+	sort.SliceStable(detail.Endpoints, func(i, j int) bool {
+		return detail.Endpoints[i].Pod < detail.Endpoints[j].Pod
+	})
+
+	c.JSON(http.StatusOK, detail)
+}
+
+// GetIngresses lists Ingresses with their hosts, paths, backends and TLS secrets
+func GetIngresses(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	request, ok := parseListRequest(c, "age")
+	if !ok {
+		return
+	}
+
+	respondList(c, clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[IngressResource], error) {
+		ingresses, err := clientset.NetworkingV1().Ingresses(namespace).List(context.TODO(), opts)
+		if err != nil {
+			return namespacePage[IngressResource]{}, err
+		}
+		page := namespacePage[IngressResource]{meta: ingresses.ListMeta}
+		for _, ing := range ingresses.Items {
+			page.items = append(page.items, ingressResource(ing))
+		}
+		return page, nil
+	})
+}
+
+// GetIngress returns an Ingress and whether each of its backend services has
+// ready endpoints
+func GetIngress(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	namespace := c.Param("namespace")
+
+	ing, err := clientset.NetworkingV1().Ingresses(namespace).Get(context.TODO(), c.Param("name"), metav1.GetOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	resource := ingressResource(*ing)
+	backendReady := make(map[string]bool)
+	for i, path := range resource.Paths {
+		if path.Service == "" {
+			continue
+		}
+		ready, checked := backendReady[path.Service]
+		if !checked {
+			slices, err := serviceEndpointSlices(clientset, namespace, path.Service)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				log.Printf("Response status: %d", http.StatusInternalServerError)
+				return
+			}
+			readyCount, _ := countEndpoints(slices)
+			ready = readyCount > 0
+			backendReady[path.Service] = ready
+		}
+		resource.Paths[i].BackendReady = &ready
+	}
+
+	c.JSON(http.StatusOK, resource)
+}
+
+func serviceEndpointSlices(clientset *kubernetes.Clientset, namespace, serviceName string) ([]discoveryv1.EndpointSlice, error) {
+	slices, err := clientset.DiscoveryV1().EndpointSlices(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + serviceName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slices.Items, nil
+}
+
+// serviceResource computes the dashboard's columns for a single Service from
+// the Service and its EndpointSlices
+func serviceResource(svc corev1.Service, slices []discoveryv1.EndpointSlice) ServiceResource {
+	var ports []string
+	for _, port := range svc.Spec.Ports {
+		// Same notation as the PORT(S) column of kubectl get services
+		if port.NodePort != 0 {
+			ports = append(ports, fmt.Sprintf("%d:%d/%s", port.Port, port.NodePort, port.Protocol))
+		} else {
+			ports = append(ports, fmt.Sprintf("%d/%s", port.Port, port.Protocol))
+		}
+	}
+
+	ready, notReady := countEndpoints(slices)
+
+	return ServiceResource{
+		Name:              svc.Name,
+		Namespace:         svc.Namespace,
+		Type:              string(svc.Spec.Type),
+		ClusterIP:         svc.Spec.ClusterIP,
+		ExternalIPs:       svc.Spec.ExternalIPs,
+		Ports:             ports,
+		Selector:          svc.Spec.Selector,
+		ReadyEndpoints:    ready,
+		NotReadyEndpoints: notReady,
+		// Services without a selector have their endpoints managed by hand
+		NoReadyBackends: len(svc.Spec.Selector) > 0 && svc.Spec.Type != corev1.ServiceTypeExternalName && ready == 0,
+		Age:             formatDuration(time.Since(svc.CreationTimestamp.Time)),
+		Labels:          svc.Labels,
+		ResourceType:    "Service",
+		createdAt:       svc.CreationTimestamp.Time,
+	}
+}
+
+// countEndpoints counts the ready and not ready backends across slices. A
+// backend listed in several slices, like a pod of a dual-stack Service, is
+// counted once and is ready when any of its endpoints is.
+func countEndpoints(slices []discoveryv1.EndpointSlice) (int, int) {
+	backends := make(map[string]bool)
+	// Endpoints without a targetRef cannot be matched across address
+	// families, so only the family listing the most of them is counted
+	unreferenced := make(map[discoveryv1.AddressType][2]int)
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.TargetRef == nil {
+				counts := unreferenced[slice.AddressType]
+				if endpointReady(endpoint) {
+					counts[0]++
+				} else {
+					counts[1]++
+				}
+				unreferenced[slice.AddressType] = counts
+				continue
+			}
+			key := endpointKey(slice, endpoint)
+			backends[key] = backends[key] || endpointReady(endpoint)
+		}
+	}
+
+	var ready, notReady int
+	for _, isReady := range backends {
+		if isReady {
+			ready++
+		} else {
+			notReady++
+		}
+	}
+	var most [2]int
+	for _, counts := range unreferenced {
+		if counts[0]+counts[1] > most[0]+most[1] {
+			most = counts
+		}
+	}
+	return ready + most[0], notReady + most[1]
+}
+
+// endpointKey identifies the backend behind an endpoint: the object its
+// targetRef points at, or else its addresses within the slice's family
+func endpointKey(slice discoveryv1.EndpointSlice, endpoint discoveryv1.Endpoint) string {
+	if ref := endpoint.TargetRef; ref != nil {
+		return ref.Kind + "/" + ref.Namespace + "/" + ref.Name
+	}
+	return string(slice.AddressType) + "/" + strings.Join(endpoint.Addresses, ",")
+}
+
+// endpointReady follows the EndpointSlice API: an unknown ready condition
+// is to be interpreted as ready
+func endpointReady(endpoint discoveryv1.Endpoint) bool {
+	return endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready
+}
+
+// ingressResource computes the dashboard's columns for a single Ingress
+func ingressResource(ing networkingv1.Ingress) IngressResource {
+	resource := IngressResource{
+		Name:         ing.Name,
+		Namespace:    ing.Namespace,
+		Hosts:        []string{},
+		Paths:        []IngressPath{},
+		TLS:          []IngressTLS{},
+		Addresses:    []string{},
+		Age:          formatDuration(time.Since(ing.CreationTimestamp.Time)),
+		Labels:       ing.Labels,
+		ResourceType: "Ingress",
+		createdAt:    ing.CreationTimestamp.Time,
+	}
+	if ing.Spec.IngressClassName != nil {
+		resource.Class = *ing.Spec.IngressClassName
+	}
+
+	if backend := ing.Spec.DefaultBackend; backend != nil {
+		resource.Paths = append(resource.Paths, ingressPath("*", "", nil, *backend))
+	}
+	for _, rule := range ing.Spec.Rules {
+		host := rule.Host
+		if host == "" {
+			host = "*"
+		}
+		if !containsString(resource.Hosts, host) {
+			resource.Hosts = append(resource.Hosts, host)
+		}
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			resource.Paths = append(resource.Paths, ingressPath(host, path.Path, path.PathType, path.Backend))
+		}
+	}
+
+	for _, tls := range ing.Spec.TLS {
+		resource.TLS = append(resource.TLS, IngressTLS{Hosts: tls.Hosts, SecretName: tls.SecretName})
+	}
+	for _, lb := range ing.Status.LoadBalancer.Ingress {
+		if lb.IP != "" {
+			resource.Addresses = append(resource.Addresses, lb.IP)
+		} else if lb.Hostname != "" {
+			resource.Addresses = append(resource.Addresses, lb.Hostname)
+		}
+	}
+
+	return resource
+}
+
+func ingressPath(host, path string, pathType *networkingv1.PathType, backend networkingv1.IngressBackend) IngressPath {
+	result := IngressPath{Host: host, Path: path}
+	if pathType != nil {
+		result.PathType = string(*pathType)
+	}
+	if backend.Service != nil {
+		result.Service = backend.Service.Name
+		if backend.Service.Port.Name != "" {
+			result.Port = backend.Service.Port.Name
+		} else {
+			result.Port = fmt.Sprintf("%d", backend.Service.Port.Number)
+		}
+	}
+	if backend.Resource != nil {
+		result.Resource = backend.Resource.Kind + "/" + backend.Resource.Name
+	}
+	return result
+}
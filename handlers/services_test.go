@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+)
+
+func TestCountEndpoints(t *testing.T) {
+	ready := func(ok bool) discoveryv1.EndpointConditions { return discoveryv1.EndpointConditions{Ready: &ok} }
+	pod := func(name, address string, conditions discoveryv1.EndpointConditions) discoveryv1.Endpoint {
+		return discoveryv1.Endpoint{
+			Addresses:  []string{address},
+			Conditions: conditions,
+			TargetRef:  &corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: name},
+		}
+	}
+	manual := func(address string) discoveryv1.Endpoint {
+		return discoveryv1.Endpoint{Addresses: []string{address}}
+	}
+	slice := func(family discoveryv1.AddressType, endpoints ...discoveryv1.Endpoint) discoveryv1.EndpointSlice {
+		return discoveryv1.EndpointSlice{AddressType: family, Endpoints: endpoints}
+	}
+
+	tests := []struct {
+		name         string
+		slices       []discoveryv1.EndpointSlice
+		wantReady    int
+		wantNotReady int
+	}{
+		{name: "no slices"},
+		{
+			name:         "single stack",
+			slices:       []discoveryv1.EndpointSlice{slice(discoveryv1.AddressTypeIPv4, pod("web-a", "10.0.0.1", ready(true)), pod("web-b", "10.0.0.2", ready(false)))},
+			wantReady:    1,
+			wantNotReady: 1,
+		},
+		{
+			name:      "unknown ready condition counts as ready",
+			slices:    []discoveryv1.EndpointSlice{slice(discoveryv1.AddressTypeIPv4, pod("web-a", "10.0.0.1", discoveryv1.EndpointConditions{}))},
+			wantReady: 1,
+		},
+		{
+			name: "dual stack counts each pod once",
+			slices: []discoveryv1.EndpointSlice{
+				slice(discoveryv1.AddressTypeIPv4, pod("web-a", "10.0.0.1", ready(true)), pod("web-b", "10.0.0.2", ready(false))),
+				slice(discoveryv1.AddressTypeIPv6, pod("web-a", "fd00::1", ready(true)), pod("web-b", "fd00::2", ready(false))),
+			},
+			wantReady:    1,
+			wantNotReady: 1,
+		},
+		{
+			name: "pod split over several slices of one family",
+			slices: []discoveryv1.EndpointSlice{
+				slice(discoveryv1.AddressTypeIPv4, pod("web-a", "10.0.0.1", ready(true))),
+				slice(discoveryv1.AddressTypeIPv4, pod("web-b", "10.0.0.2", ready(true))),
+			},
+			wantReady: 2,
+		},
+		{
+			name: "ready in either family",
+			slices: []discoveryv1.EndpointSlice{
+				slice(discoveryv1.AddressTypeIPv4, pod("web-a", "10.0.0.1", ready(false))),
+				slice(discoveryv1.AddressTypeIPv6, pod("web-a", "fd00::1", ready(true))),
+			},
+			wantReady: 1,
+		},
+		{
+			name: "manual dual stack endpoints count the larger family",
+			slices: []discoveryv1.EndpointSlice{
+				slice(discoveryv1.AddressTypeIPv4, manual("10.0.0.1"), manual("10.0.0.2")),
+				slice(discoveryv1.AddressTypeIPv6, manual("fd00::1")),
+			},
+			wantReady: 2,
+		},
+		{
+			name: "manual and pod endpoints",
+			slices: []discoveryv1.EndpointSlice{
+				slice(discoveryv1.AddressTypeIPv4, pod("web-a", "10.0.0.1", ready(true)), manual("192.168.0.1")),
+				slice(discoveryv1.AddressTypeIPv6, pod("web-a", "fd00::1", ready(true))),
+			},
+			wantReady: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotReady, gotNotReady := countEndpoints(test.slices)
+			if gotReady != test.wantReady || gotNotReady != test.wantNotReady {
+				t.Errorf("got %d ready and %d not ready, want %d and %d", gotReady, gotNotReady, test.wantReady, test.wantNotReady)
+			}
+		})
+	}
+}
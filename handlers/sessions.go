@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sessions expire after sessionIdleTimeoutEnv without activity, and in any
+// case sessionAbsoluteTimeoutEnv after they started. Both take Go durations
+// such as "30m" or "8h".
+const (
+	sessionIdleTimeoutEnv     = "SESSION_IDLE_TIMEOUT"
+	sessionAbsoluteTimeoutEnv = "SESSION_ABSOLUTE_TIMEOUT"
+)
+
+const (
+	defaultSessionIdleTimeout     = 1 * time.Hour
+	defaultSessionAbsoluteTimeout = 12 * time.Hour
+)
+
+var (
+	sessionsMu sync.RWMutex
+	sessions   = make(map[string]SessionData)
+)
+
+// newSessionToken returns an unguessable session token. Whoever holds it acts
+// as the session's user, with the dashboard's own service account in
+// in-cluster mode.
+func newSessionToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func getSession(sessionToken string) (SessionData, bool) {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	session, exists := sessions[sessionToken]
+	return session, exists
+}
+
+func saveSession(sessionToken string, session SessionData) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	sessions[sessionToken] = session
+}
+
+// updateSession changes a session in place, so concurrent requests do not
+// overwrite each other's changes with stale copies. It returns the updated
+// session, or false when it no longer exists.
+func updateSession(sessionToken string, update func(*SessionData) error) (SessionData, bool, error) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	session, exists := sessions[sessionToken]
+	if !exists {
+		return session, false, nil
+	}
+	if err := update(&session); err != nil {
+		return session, true, err
+	}
+	sessions[sessionToken] = session
+	return session, true, nil
+}
+
+func deleteSession(sessionToken string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	delete(sessions, sessionToken)
+}
+
+// expireSessions deletes the expired sessions and returns their tokens
+func expireSessions() []string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	var expired []string
+	for token, session := range sessions {
+		if time.Now().After(session.ExpiresAt) {
+			delete(sessions, token)
+			expired = append(expired, token)
+		}
+	}
+	return expired
+}
+
+// checkSessionTimeouts reports invalid timeouts at startup
+func checkSessionTimeouts() error {
+	idle, err := sessionTimeout(sessionIdleTimeoutEnv, defaultSessionIdleTimeout)
+	if err != nil {
+		return err
+	}
+	absolute, err := sessionTimeout(sessionAbsoluteTimeoutEnv, defaultSessionAbsoluteTimeout)
+	if err != nil {
+		return err
+	}
+	if absolute < idle {
+		return fmt.Errorf("%s must not be shorter than %s", sessionAbsoluteTimeoutEnv, sessionIdleTimeoutEnv)
+	}
+	return nil
+}
+
+func sessionTimeout(env string, defaultTimeout time.Duration) (time.Duration, error) {
+	raw := strings.TrimSpace(os.Getenv(env))
+	if raw == "" {
+		return defaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		return defaultTimeout, fmt.Errorf("invalid %s %q: must be a positive duration such as 30m", env, raw)
+	}
+	return timeout, nil
+}
+
+func sessionIdleTimeout() time.Duration {
+	timeout, _ := sessionTimeout(sessionIdleTimeoutEnv, defaultSessionIdleTimeout)
+	return timeout
+}
+
+func sessionAbsoluteTimeout() time.Duration {
+	timeout, _ := sessionTimeout(sessionAbsoluteTimeoutEnv, defaultSessionAbsoluteTimeout)
+	return timeout
+}
+
+// sessionLimit is the latest the session can last however active it is: its
+// absolute timeout, or the expiry of its credentials when that is sooner
+func sessionLimit(session SessionData) time.Time {
+	limit := session.CreatedAt.Add(sessionAbsoluteTimeout())
+	if session.CredentialsExpireAt != nil && session.CredentialsExpireAt.Before(limit) {
+		limit = *session.CredentialsExpireAt
+	}
+	return limit
+}
+
+// renewSession slides the session's expiry to the idle timeout from now,
+// never past its limit
+func renewSession(session *SessionData) {
+	session.ExpiresAt = time.Now().Add(sessionIdleTimeout())
+	if limit := sessionLimit(*session); limit.Before(session.ExpiresAt) {
+		session.ExpiresAt = limit
+	}
+}
+
+// sessionCookieMaxAge is the cookie lifetime in seconds. The cookie lasts as
+// long as the session can; the idle timeout is enforced by the server, since
+// a session is only renewed once the response has been written.
+func sessionCookieMaxAge(session SessionData) int {
+	return int(time.Until(sessionLimit(session)).Seconds())
+}
+
+// SessionActivityMiddleware renews the session after every successful
+// request, so active users are not logged out mid-task. It is only used for
+// the authenticated API: static assets, failed requests and the frontend
+// polling AuthCheck do not keep an idle session alive.
+func SessionActivityMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		if sessionToken, err := c.Cookie("sessionToken"); err == nil {
+			updateSession(sessionToken, func(session *SessionData) error {
+				if time.Now().Before(session.ExpiresAt) {
+					renewSession(session)
+				}
+				return nil
+			})
+		}
+	}
+}
+
+// RefreshSession renews the session explicitly, such as when the user answers
+// the frontend's warning that they are about to be logged out. OIDC tokens
+// that are due are refreshed too.
+func RefreshSession(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	session, err = refreshOIDCSession(sessionToken, session)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	session, exists, _ = updateSession(sessionToken, func(session *SessionData) error {
+		renewSession(session)
+		return nil
+	})
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessionLifetime(session))
+}
+
+// sessionLifetime describes how long the session has left and what limits it
+func sessionLifetime(session SessionData) gin.H {
+	limit := sessionLimit(session)
+	return gin.H{
+		"expiresAt":          session.ExpiresAt,
+		"remainingSeconds":   int(time.Until(session.ExpiresAt).Seconds()),
+		"absoluteExpiresAt":  limit,
+		"idleTimeoutSeconds": int(sessionIdleTimeout().Seconds()),
+		// Renewing cannot help once the session is at its limit
+		"renewable": session.ExpiresAt.Before(limit),
+	}
+}
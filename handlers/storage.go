@@ -0,0 +1,434 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type PersistentVolumeClaimResource struct {
+	Name         string   `json:"name"`
+	Namespace    string   `json:"namespace"`
+	Status       string   `json:"status"`
+	Volume       string   `json:"volume"`
+	Capacity     string   `json:"capacity"`
+	AccessModes  []string `json:"accessModes"`
+	StorageClass string   `json:"storageClass"`
+	MountedBy    []string `json:"mountedBy"`
+	// NotBound is set for Pending and Lost claims
+	NotBound bool `json:"notBound"`
+	// Orphaned is set for claims left behind by a StatefulSet that was scaled
+	// down or deleted; OrphanReason says which. PossiblyOrphaned is set
+	// instead when the claim only looks like one a deleted StatefulSet made.
+	Orphaned         bool              `json:"orphaned"`
+	PossiblyOrphaned bool              `json:"possiblyOrphaned"`
+	OrphanReason     string            `json:"orphanReason,omitempty"`
+	Age              string            `json:"age"`
+	Labels           map[string]string `json:"labels"`
+	ResourceType     string            `json:"resourceType"`
+
+	createdAt time.Time
+}
+
+func (r PersistentVolumeClaimResource) sortKey() sortKey {
+	return sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt}
+}
+
+type PersistentVolumeResource struct {
+	Name          string            `json:"name"`
+	Status        string            `json:"status"`
+	Claim         string            `json:"claim"`
+	Capacity      string            `json:"capacity"`
+	AccessModes   []string          `json:"accessModes"`
+	ReclaimPolicy string            `json:"reclaimPolicy"`
+	StorageClass  string            `json:"storageClass"`
+	Reason        string            `json:"reason,omitempty"`
+	Age           string            `json:"age"`
+	Labels        map[string]string `json:"labels"`
+	ResourceType  string            `json:"resourceType"`
+
+	createdAt time.Time
+}
+
+func (r PersistentVolumeResource) sortKey() sortKey {
+	return sortKey{name: r.Name, created: r.createdAt}
+}
+
+type PersistentVolumeClaimDetail struct {
+	PersistentVolumeClaimResource
+	Conditions       []string                  `json:"conditions"`
+	PersistentVolume *PersistentVolumeResource `json:"persistentVolume,omitempty"`
+}
+
+// GetPersistentVolumeClaims lists PVCs with the pods mounting them, flagging
+// unbound claims and claims orphaned by StatefulSets
+func GetPersistentVolumeClaims(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	request, ok := parseListRequest(c, "age")
+	if !ok {
+		return
+	}
+
+	respondList(c, clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[PersistentVolumeClaimResource], error) {
+		claims, err := clientset.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), opts)
+		if err != nil {
+			return namespacePage[PersistentVolumeClaimResource]{}, err
+		}
+		owners, err := listClaimOwners(clientset, namespace)
+		if err != nil {
+			return namespacePage[PersistentVolumeClaimResource]{}, err
+		}
+
+		page := namespacePage[PersistentVolumeClaimResource]{meta: claims.ListMeta}
+		for _, claim := range claims.Items {
+			page.items = append(page.items, persistentVolumeClaimResource(claim, owners))
+		}
+		return page, nil
+	})
+}
+
+// GetPersistentVolumeClaim returns a PVC with its conditions and bound volume
+func GetPersistentVolumeClaim(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	namespace := c.Param("namespace")
+	claimName := c.Param("name")
+
+	claim, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), claimName, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+	owners, err := listClaimOwners(clientset, namespace)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	detail := PersistentVolumeClaimDetail{
+		PersistentVolumeClaimResource: persistentVolumeClaimResource(*claim, owners),
+		Conditions:                    []string{},
+	}
+	for _, condition := range claim.Status.Conditions {
+		text := string(condition.Type) + "=" + string(condition.Status)
+		if condition.Message != "" {
+			text += ": " + condition.Message
+		}
+		detail.Conditions = append(detail.Conditions, text)
+	}
+	if claim.Spec.VolumeName != "" {
+		volume, err := clientset.CoreV1().PersistentVolumes().Get(context.TODO(), claim.Spec.VolumeName, metav1.GetOptions{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("Response status: %d", http.StatusInternalServerError)
+			return
+		}
+		item := persistentVolumeResource(*volume)
+		detail.PersistentVolume = &item
+	}
+
+	c.JSON(http.StatusOK, detail)
+}
+
+// GetPersistentVolumes lists the cluster's PersistentVolumes and their claims
+func GetPersistentVolumes(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+	listOptions, err := listOptionsFromQuery(c, nil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	order, err := listSortFromQuery(c, "age")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	volumes, err := clientset.CoreV1().PersistentVolumes().List(context.TODO(), listOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	resourceList := []PersistentVolumeResource{}
+	for _, volume := range volumes.Items {
+		resourceList = append(resourceList, persistentVolumeResource(volume))
+	}
+
+	sort.SliceStable(resourceList, func(i, j int) bool {
+		return order.less(resourceList[i].sortKey(), resourceList[j].sortKey())
+	})
+	c.JSON(http.StatusOK, ResourceList{
+		Items:              resourceList,
+		LabelSelector:      listOptions.LabelSelector,
+		FieldSelector:      listOptions.FieldSelector,
+		Continue:           volumes.Continue,
+		RemainingItemCount: volumes.RemainingItemCount,
+	})
+}
+
+func GetPersistentVolume(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+
+	volume, err := clientset.CoreV1().PersistentVolumes().Get(context.TODO(), c.Param("name"), metav1.GetOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, persistentVolumeResource(*volume))
+}
+
+// claimOwners is what a namespace's PVCs are checked against: the pods
+// mounting each claim and the StatefulSets that may have created them
+type claimOwners struct {
+	mountedBy    map[string][]string
+	statefulSets map[string]appsv1.StatefulSet
+}
+
+func listClaimOwners(clientset *kubernetes.Clientset, namespace string) (claimOwners, error) {
+	owners := claimOwners{
+		mountedBy:    make(map[string][]string),
+		statefulSets: make(map[string]appsv1.StatefulSet),
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return owners, err
+	}
+	for _, pod := range pods.Items {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				key := pod.Namespace + "/" + volume.PersistentVolumeClaim.ClaimName
+				owners.mountedBy[key] = append(owners.mountedBy[key], pod.Name)
+			}
+		}
+	}
+
+	statefulSets, err := clientset.AppsV1().StatefulSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return owners, err
+	}
+	for _, ss := range statefulSets.Items {
+		owners.statefulSets[ss.Namespace+"/"+ss.Name] = ss
+	}
+	return owners, nil
+}
+
+// persistentVolumeClaimResource computes the dashboard's columns for a single PVC
+func persistentVolumeClaimResource(claim corev1.PersistentVolumeClaim, owners claimOwners) PersistentVolumeClaimResource {
+	capacity := claim.Status.Capacity[corev1.ResourceStorage]
+	if claim.Status.Phase != corev1.ClaimBound {
+		// An unbound claim has no capacity yet, so show what it asks for
+		capacity = claim.Spec.Resources.Requests[corev1.ResourceStorage]
+	}
+	storageClass := ""
+	if claim.Spec.StorageClassName != nil {
+		storageClass = *claim.Spec.StorageClassName
+	}
+	mountedBy := owners.mountedBy[claim.Namespace+"/"+claim.Name]
+	if mountedBy == nil {
+		mountedBy = []string{}
+	}
+	sort.Strings(mountedBy)
+
+	item := PersistentVolumeClaimResource{
+		Name:         claim.Name,
+		Namespace:    claim.Namespace,
+		Status:       string(claim.Status.Phase),
+		Volume:       claim.Spec.VolumeName,
+		Capacity:     capacity.String(),
+		AccessModes:  accessModes(claim.Spec.AccessModes),
+		StorageClass: storageClass,
+		MountedBy:    mountedBy,
+		NotBound:     claim.Status.Phase != corev1.ClaimBound,
+		Age:          formatDuration(time.Since(claim.CreationTimestamp.Time)),
+		Labels:       claim.Labels,
+		ResourceType: "PersistentVolumeClaim",
+		createdAt:    claim.CreationTimestamp.Time,
+	}
+	// A claim still mounted somewhere is in use, whoever created it
+	if len(mountedBy) == 0 {
+		var confirmed bool
+		item.OrphanReason, confirmed = statefulSetOrphanReason(claim, owners.statefulSets)
+		item.Orphaned = item.OrphanReason != "" && confirmed
+		item.PossiblyOrphaned = item.OrphanReason != "" && !confirmed
+	}
+	return item
+}
+
+// statefulSetOrphanReason explains why claim looks left behind by a
+// StatefulSet, or returns "" when it does not. The reason is confirmed when the
+// claim is owned by a StatefulSet that is gone, or is a live StatefulSet's
+// claim beyond its replicas: named <template>-<statefulset>-<ordinal> after one
+// of its volumeClaimTemplates and labelled with its selector, as the controller
+// does. Under the default Retain policy the claims of a deleted StatefulSet
+// keep no owner reference and their templates are gone with it, so a name like
+// that no live StatefulSet accounts for only makes the claim possibly orphaned.
+func statefulSetOrphanReason(claim corev1.PersistentVolumeClaim, statefulSets map[string]appsv1.StatefulSet) (string, bool) {
+	for _, owner := range claim.OwnerReferences {
+		if owner.Kind != "StatefulSet" {
+			// Something else, such as an operator, manages the claim
+			return "", false
+		}
+		ss, ok := statefulSets[claim.Namespace+"/"+owner.Name]
+		if !ok || ss.UID != owner.UID {
+			return fmt.Sprintf("StatefulSet %s was deleted", owner.Name), true
+		}
+	}
+
+	prefix, ordinal, ok := splitOrdinal(claim.Name)
+	if !ok {
+		return "", false
+	}
+	for _, ss := range statefulSets {
+		if ss.Namespace != claim.Namespace || !claimOfStatefulSet(claim, prefix, ss) {
+			continue
+		}
+		replicas := 1
+		if ss.Spec.Replicas != nil {
+			replicas = int(*ss.Spec.Replicas)
+		}
+		if ordinal >= replicas {
+			return fmt.Sprintf("ordinal %d is beyond the %d replicas of StatefulSet %s", ordinal, replicas, ss.Name), true
+		}
+		return "", false
+	}
+	if len(claim.OwnerReferences) > 0 || !strings.Contains(prefix, "-") {
+		return "", false
+	}
+	return "named like a StatefulSet claim, but no StatefulSet in the namespace has a matching volumeClaimTemplate", false
+}
+
+// claimOfStatefulSet reports whether a claim named prefix-<ordinal> is one the
+// controller made from a volumeClaimTemplate of ss
+func claimOfStatefulSet(claim corev1.PersistentVolumeClaim, prefix string, ss appsv1.StatefulSet) bool {
+	if ss.Spec.Selector == nil {
+		return false
+	}
+	for key, value := range ss.Spec.Selector.MatchLabels {
+		if claim.Labels[key] != value {
+			return false
+		}
+	}
+	for _, template := range ss.Spec.VolumeClaimTemplates {
+		if prefix == template.Name+"-"+ss.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// splitOrdinal splits a name ending in -<ordinal> into the rest and the ordinal
+func splitOrdinal(name string) (string, int, bool) {
+	i := strings.LastIndex(name, "-")
+	if i <= 0 || i == len(name)-1 {
+		return "", 0, false
+	}
+	for _, r := range name[i+1:] {
+		if r < '0' || r > '9' {
+			return "", 0, false
+		}
+	}
+	ordinal, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return name[:i], ordinal, true
+}
+
+// persistentVolumeResource computes the dashboard's columns for a single PV
+func persistentVolumeResource(volume corev1.PersistentVolume) PersistentVolumeResource {
+	capacity := volume.Spec.Capacity[corev1.ResourceStorage]
+	claim := ""
+	if volume.Spec.ClaimRef != nil {
+		claim = volume.Spec.ClaimRef.Namespace + "/" + volume.Spec.ClaimRef.Name
+	}
+	return PersistentVolumeResource{
+		Name:          volume.Name,
+		Status:        string(volume.Status.Phase),
+		Claim:         claim,
+		Capacity:      capacity.String(),
+		AccessModes:   accessModes(volume.Spec.AccessModes),
+		ReclaimPolicy: string(volume.Spec.PersistentVolumeReclaimPolicy),
+		StorageClass:  volume.Spec.StorageClassName,
+		Reason:        volume.Status.Reason,
+		Age:           formatDuration(time.Since(volume.CreationTimestamp.Time)),
+		Labels:        volume.Labels,
+		ResourceType:  "PersistentVolume",
+		createdAt:     volume.CreationTimestamp.Time,
+	}
+}
+
+// accessModes abbreviates access modes the way kubectl prints them
+func accessModes(modes []corev1.PersistentVolumeAccessMode) []string {
+	short := map[corev1.PersistentVolumeAccessMode]string{
+		corev1.ReadWriteOnce:    "RWO",
+		corev1.ReadOnlyMany:     "ROX",
+		corev1.ReadWriteMany:    "RWX",
+		corev1.ReadWriteOncePod: "RWOP",
+	}
+	result := []string{}
+	for _, mode := range modes {
+		if abbreviation, ok := short[mode]; ok {
+			result = append(result, abbreviation)
+		} else {
+			result = append(result, string(mode))
+		}
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestStatefulSetOrphanReason(t *testing.T) {
+	replicas := func(n int32) *int32 { return &n }
+	statefulSet := func(name string, uid types.UID, n int32, templates ...string) appsv1.StatefulSet {
+		ss := appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: uid},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: replicas(n),
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			},
+		}
+		for _, template := range templates {
+			ss.Spec.VolumeClaimTemplates = append(ss.Spec.VolumeClaimTemplates, corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: template}})
+		}
+		return ss
+	}
+	// claim is labelled for the StatefulSet app, as the controller does
+	claim := func(name, app string, owners ...metav1.OwnerReference) corev1.PersistentVolumeClaim {
+		claim := corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", OwnerReferences: owners}}
+		if app != "" {
+			claim.Labels = map[string]string{"app": app}
+		}
+		return claim
+	}
+	owner := func(kind, name string, uid types.UID) metav1.OwnerReference {
+		return metav1.OwnerReference{Kind: kind, Name: name, UID: uid}
+	}
+	live := map[string]appsv1.StatefulSet{
+		"default/web":     statefulSet("web", "uid-web", 2, "data"),
+		"default/db-main": statefulSet("db-main", "uid-db", 1, "pg-data"),
+		"default/main":    statefulSet("main", "uid-main", 1, "data"),
+	}
+
+	tests := []struct {
+		name          string
+		claim         corev1.PersistentVolumeClaim
+		want          string
+		wantConfirmed bool
+	}{
+		{name: "claim within replicas", claim: claim("data-web-1", "web"), want: ""},
+		{name: "claim beyond replicas", claim: claim("data-web-2", "web"), want: "ordinal 2 is beyond the 2 replicas of StatefulSet web", wantConfirmed: true},
+		{name: "dashed names within replicas", claim: claim("pg-data-db-main-0", "db-main"), want: ""},
+		{name: "dashed names beyond replicas", claim: claim("pg-data-db-main-3", "db-main"), want: "beyond the 1 replicas of StatefulSet db-main", wantConfirmed: true},
+		{name: "suffix StatefulSet does not claim another's template", claim: claim("pg-data-db-main-3", "main"), want: "no StatefulSet in the namespace has a matching volumeClaimTemplate"},
+		{name: "suffix StatefulSet claim beyond replicas", claim: claim("data-main-1", "main"), want: "beyond the 1 replicas of StatefulSet main", wantConfirmed: true},
+		{name: "deleted StatefulSet whose name is a suffix of a live one", claim: claim("data-db-main-0", "db-main"), want: "no StatefulSet in the namespace has a matching volumeClaimTemplate"},
+		{name: "matching name without the selector labels", claim: claim("data-web-2", ""), want: "no StatefulSet in the namespace has a matching volumeClaimTemplate"},
+		{name: "unknown template of a live StatefulSet", claim: claim("logs-web-0", "web"), want: "no StatefulSet in the namespace has a matching volumeClaimTemplate"},
+		{name: "deleted StatefulSet with Retain policy", claim: claim("data-cache-0", "cache"), want: "no StatefulSet in the namespace has a matching volumeClaimTemplate"},
+		{name: "unrelated claim named like one", claim: claim("backup-volume-2", ""), want: "no StatefulSet in the namespace has a matching volumeClaimTemplate"},
+		{name: "deleted StatefulSet with Delete policy", claim: claim("data-cache-0", "cache", owner("StatefulSet", "cache", "uid-cache")), want: "StatefulSet cache was deleted", wantConfirmed: true},
+		{name: "StatefulSet recreated under the same name", claim: claim("data-web-0", "web", owner("StatefulSet", "web", "uid-old")), want: "StatefulSet web was deleted", wantConfirmed: true},
+		{name: "owned by the live StatefulSet", claim: claim("data-web-0", "web", owner("StatefulSet", "web", "uid-web")), want: ""},
+		{name: "owned by something else", claim: claim("data-cache-0", "cache", owner("Cluster", "cache", "uid-operator")), want: ""},
+		{name: "no ordinal", claim: claim("shared-data", ""), want: ""},
+		{name: "no template part", claim: claim("cache-0", ""), want: ""},
+		{name: "signed suffix", claim: claim("data-cache-+1", ""), want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, confirmed := statefulSetOrphanReason(test.claim, live)
+			if test.want == "" && got != "" || !strings.Contains(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if confirmed != test.wantConfirmed {
+				t.Errorf("got confirmed %v, want %v", confirmed, test.wantConfirmed)
+			}
+		})
+	}
+}
+
+func TestSplitOrdinal(t *testing.T) {
+	tests := []struct {
+		name        string
+		wantPrefix  string
+		wantOrdinal int
+		wantOK      bool
+	}{
+		{name: "data-web-0", wantPrefix: "data-web", wantOrdinal: 0, wantOK: true},
+		{name: "data-web-12", wantPrefix: "data-web", wantOrdinal: 12, wantOK: true},
+		{name: "data-web-", wantOK: false},
+		{name: "-0", wantOK: false},
+		{name: "data", wantOK: false},
+		{name: "data-web-x1", wantOK: false},
+	}
+	for _, test := range tests {
+		prefix, ordinal, ok := splitOrdinal(test.name)
+		if prefix != test.wantPrefix || ordinal != test.wantOrdinal || ok != test.wantOK {
+			t.Errorf("splitOrdinal(%q) = %q, %d, %v", test.name, prefix, ordinal, ok)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WorkloadHealth counts the workloads of one kind by health state
+type WorkloadHealth struct {
+	Healthy   int `json:"healthy"`
+	Updating  int `json:"updating"`
+	Unhealthy int `json:"unhealthy"`
+}
+
+// NamespaceSummary is the health of a namespace at a glance.
+// ContainersRestartedLastHour counts containers whose last restart was within
+// the hour. The API keeps no history of restarts, so a container that
+// restarted several times counts once and this is a lower bound. Sections that
+// could not be listed are left out and explained in Warnings.
+type NamespaceSummary struct {
+	Name                        string                    `json:"name"`
+	Status                      string                    `json:"status"`
+	Healthy                     bool                      `json:"healthy"`
+	Workloads                   map[string]WorkloadHealth `json:"workloads"`
+	Pods                        map[string]int            `json:"pods"`
+	ContainersRestartedLastHour int                       `json:"containersRestartedLastHour"`
+	WarningEvents               int                       `json:"warningEvents"`
+	Quotas                      []QuotaUsage              `json:"quotas"`
+	Labels                      map[string]string         `json:"labels"`
+	Annotations                 map[string]string         `json:"annotations"`
+	Warnings                    []string                  `json:"warnings,omitempty"`
+}
+
+// GetNamespaceSummaries returns a NamespaceSummary for each selected
+// namespace, so the landing page can show a health matrix
+func GetNamespaceSummaries(c *gin.Context) {
+	sessionToken, err := c.Cookie("sessionToken")
+	session, exists := getSession(sessionToken)
+	if err != nil || !exists || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	clientset, err := sessionClientset(c, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate kubeconfig: " + err.Error()})
+		return
+	}
+
+	// Summaries are always built namespace by namespace
+	namespaces := parseNamespaces(c.Param("namespace"))
+	if len(namespaces) == 1 && namespaces[0] == metav1.NamespaceAll {
+		nsList, err := clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("Response status: %d", http.StatusInternalServerError)
+			return
+		}
+		namespaces = nil
+		for _, ns := range nsList.Items {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+
+	var mu sync.Mutex
+	summaries := []NamespaceSummary{}
+	errs, ok := listAcrossNamespaces(clientset, namespaces, metav1.ListOptions{}, func(namespace string, _ metav1.ListOptions) error {
+		summary, err := namespaceSummary(clientset, namespace)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		summaries = append(summaries, summary)
+		return nil
+	})
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": joinNamespaceErrors(errs)})
+		log.Printf("Response status: %d", http.StatusInternalServerError)
+		return
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	c.JSON(http.StatusOK, ResourceList{Items: summaries, Errors: errs})
+}
+
+// namespaceSummary builds the summary of one namespace. Only a namespace that
+// does not exist is an error; any section the user cannot list is left out
+// with a warning, so the namespace keeps its row.
+func namespaceSummary(clientset *kubernetes.Clientset, namespace string) (NamespaceSummary, error) {
+	summary := NamespaceSummary{
+		Name:      namespace,
+		Workloads: make(map[string]WorkloadHealth),
+		Pods:      make(map[string]int),
+		Quotas:    []QuotaUsage{},
+	}
+	warn := func(section string, err error) {
+		summary.Warnings = append(summary.Warnings, section+" unavailable: "+err.Error())
+	}
+
+	ns, err := clientset.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return summary, err
+	case err != nil:
+		warn("namespace", err)
+	default:
+		summary.Status = string(ns.Status.Phase)
+		summary.Labels = ns.Labels
+		summary.Annotations = ns.Annotations
+	}
+
+	if deployments, err := clientset.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{}); err != nil {
+		warn("Deployments", err)
+	} else {
+		health := WorkloadHealth{}
+		for _, d := range deployments.Items {
+			countWorkloadHealth(&health, deploymentResource(d).Ready)
+		}
+		summary.Workloads["Deployment"] = health
+	}
+
+	if statefulSets, err := clientset.AppsV1().StatefulSets(namespace).List(context.TODO(), metav1.ListOptions{}); err != nil {
+		warn("StatefulSets", err)
+	} else {
+		health := WorkloadHealth{}
+		for _, ss := range statefulSets.Items {
+			countWorkloadHealth(&health, statefulSetResource(ss).Ready)
+		}
+		summary.Workloads["StatefulSet"] = health
+	}
+
+	if daemonSets, err := clientset.AppsV1().DaemonSets(namespace).List(context.TODO(), metav1.ListOptions{}); err != nil {
+		warn("DaemonSets", err)
+	} else {
+		health := WorkloadHealth{}
+		for _, ds := range daemonSets.Items {
+			switch {
+			case ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled:
+				health.Updating++
+			case ds.Status.NumberReady < ds.Status.DesiredNumberScheduled:
+				health.Unhealthy++
+			default:
+				health.Healthy++
+			}
+		}
+		summary.Workloads["DaemonSet"] = health
+	}
+
+	if pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{}); err != nil {
+		warn("pods", err)
+	} else {
+		hourAgo := time.Now().Add(-time.Hour)
+		for _, pod := range pods.Items {
+			summary.Pods[string(pod.Status.Phase)]++
+			for _, status := range pod.Status.ContainerStatuses {
+				if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.FinishedAt.After(hourAgo) {
+					summary.ContainersRestartedLastHour++
+				}
+			}
+		}
+	}
+
+	if events, err := clientset.CoreV1().Events(namespace).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "type=" + corev1.EventTypeWarning,
+	}); err != nil {
+		warn("events", err)
+	} else {
+		summary.WarningEvents = len(events.Items)
+	}
+
+	if quotas, err := clientset.CoreV1().ResourceQuotas(namespace).List(context.TODO(), metav1.ListOptions{}); err != nil {
+		warn("quotas", err)
+	} else {
+		for _, quota := range quotas.Items {
+			summary.Quotas = append(summary.Quotas, quotaUsage(quota))
+		}
+	}
+
+	summary.Healthy = summary.Pods[string(corev1.PodFailed)] == 0
+	for _, health := range summary.Workloads {
+		if health.Unhealthy > 0 {
+			summary.Healthy = false
+		}
+	}
+	return summary, nil
+}
+
+// countWorkloadHealth classifies a workload by the Ready column the
+// Deployment and StatefulSet lists show, e.g. "2/3 (Updating...)"
+func countWorkloadHealth(health *WorkloadHealth, ready string) {
+	switch {
+	case strings.Contains(ready, "(Updating...)"):
+		health.Updating++
+	case strings.Contains(ready, "("):
+		health.Unhealthy++
+	default:
+		health.Healthy++
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// summaryAPIServer serves an empty namespace "team" with one failed pod that
+// restarted a few minutes ago, refusing the resources in forbidden
+func summaryAPIServer(t *testing.T, forbidden ...string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		resource := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if r.URL.Path == "/api/v1/namespaces/team" {
+			resource = "namespaces"
+		}
+		for _, refused := range forbidden {
+			if resource == refused {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Reason: metav1.StatusReasonForbidden, Code: http.StatusForbidden, Message: resource + " is forbidden"})
+				return
+			}
+		}
+
+		switch resource {
+		case "namespaces":
+			json.NewEncoder(w).Encode(corev1.Namespace{TypeMeta: metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: "team"}, Status: corev1.NamespaceStatus{Phase: corev1.NamespaceActive}})
+		case "pods":
+			restarted := corev1.ContainerStatus{LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: metav1.NewTime(time.Now().Add(-5 * time.Minute))}}}
+			json.NewEncoder(w).Encode(corev1.PodList{TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"}, Items: []corev1.Pod{
+				{Status: corev1.PodStatus{Phase: corev1.PodFailed, ContainerStatuses: []corev1.ContainerStatus{restarted}}},
+			}})
+		case "events":
+			json.NewEncoder(w).Encode(corev1.EventList{TypeMeta: metav1.TypeMeta{Kind: "EventList", APIVersion: "v1"}, Items: []corev1.Event{{Type: corev1.EventTypeWarning}}})
+		default:
+			// Empty lists of Deployments, StatefulSets, DaemonSets and quotas
+			w.Write([]byte(`{"items":[]}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNamespaceSummaryDegrades(t *testing.T) {
+	tests := []struct {
+		name          string
+		forbidden     []string
+		wantWarnings  []string
+		wantWorkloads int
+		wantPods      int
+		wantEvents    int
+	}{
+		{name: "everything readable", wantWorkloads: 3, wantPods: 1, wantEvents: 1},
+		{name: "events and quotas forbidden", forbidden: []string{"events", "resourcequotas"}, wantWarnings: []string{"events unavailable", "quotas unavailable"}, wantWorkloads: 3, wantPods: 1},
+		{name: "workloads forbidden", forbidden: []string{"deployments", "daemonsets"}, wantWarnings: []string{"Deployments unavailable", "DaemonSets unavailable"}, wantWorkloads: 1, wantPods: 1, wantEvents: 1},
+		{name: "namespace and pods forbidden", forbidden: []string{"namespaces", "pods"}, wantWarnings: []string{"namespace unavailable", "pods unavailable"}, wantWorkloads: 3, wantEvents: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := summaryAPIServer(t, test.forbidden...)
+			clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL, QPS: 1000, Burst: 1000})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			summary, err := namespaceSummary(clientset, "team")
+			if err != nil {
+				t.Fatalf("namespace dropped: %v", err)
+			}
+			if summary.Name != "team" {
+				t.Errorf("got name %q", summary.Name)
+			}
+			if len(summary.Warnings) != len(test.wantWarnings) {
+				t.Fatalf("got warnings %v, want %v", summary.Warnings, test.wantWarnings)
+			}
+			for i, want := range test.wantWarnings {
+				if !strings.HasPrefix(summary.Warnings[i], want) || !strings.Contains(summary.Warnings[i], "forbidden") {
+					t.Errorf("warning %q, want %q", summary.Warnings[i], want)
+				}
+			}
+			if len(summary.Workloads) != test.wantWorkloads || summary.Pods[string(corev1.PodFailed)] != test.wantPods || summary.WarningEvents != test.wantEvents {
+				t.Errorf("got %d workload kinds, %d failed pods and %d warning events", len(summary.Workloads), summary.Pods[string(corev1.PodFailed)], summary.WarningEvents)
+			}
+			if summary.ContainersRestartedLastHour != test.wantPods {
+				t.Errorf("got %d containers restarted", summary.ContainersRestartedLastHour)
+			}
+		})
+	}
+}
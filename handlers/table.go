@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// tableAcceptHeader asks the API server to render a list in the server-side
+// Table format, which is what kubectl uses for `kubectl get`.
+const tableAcceptHeader = "application/json;as=Table;g=meta.k8s.io;v=v1"
+
+type TableColumn struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Format      string `json:"format,omitempty"`
+	Description string `json:"description,omitempty"`
+	Priority    int32  `json:"priority"`
+}
+
+type TableRowResource struct {
+	Name         string                 `json:"name"`
+	Namespace    string                 `json:"namespace"`
+	Labels       map[string]string      `json:"labels"`
+	ResourceType string                 `json:"resourceType"`
+	Cells        map[string]interface{} `json:"cells"`
+
+	createdAt time.Time
+	restarts  int32
+}
+
+func (r TableRowResource) sortKey() sortKey {
+	return sortKey{namespace: r.Namespace, name: r.Name, created: r.createdAt, restarts: r.restarts}
+}
+
+// checkTableSort refuses the sort orders a Table cannot be sorted by. Rows
+// only carry what the API server printed, which has no metrics.
+func checkTableSort(order listSort) error {
+	switch order.By {
+	case "cpu", "memory":
+		return fmt.Errorf("invalid sortBy: %q is not supported with format=table", order.By)
+	}
+	return nil
+}
+
+type TableResource struct {
+	Columns       []TableColumn      `json:"columns"`
+	Rows          []TableRowResource `json:"rows"`
+	Errors        map[string]string  `json:"errors,omitempty"`
+	LabelSelector string             `json:"labelSelector,omitempty"`
+	FieldSelector string             `json:"fieldSelector,omitempty"`
+
+	Continue           string `json:"continue,omitempty"`
+	RemainingItemCount *int64 `json:"remainingItemCount,omitempty"`
+}
+
+// getTableAcrossNamespaces is getTable for every namespace of request.
+// Rows of all namespaces are merged under the columns of the first response;
+// ok is false when no namespace could be listed.
+func getTableAcrossNamespaces(clientset *kubernetes.Clientset, restClient rest.Interface, resource string, request listRequest, resourceType string) (*TableResource, bool) {
+	var mu sync.Mutex
+	columns := []TableColumn{}
+	page, errs, ok := listPages(clientset, request, func(namespace string, opts metav1.ListOptions) (namespacePage[TableRowResource], error) {
+		table, err := getTable(restClient, resource, namespace, opts, resourceType)
+		if err != nil {
+			return namespacePage[TableRowResource]{}, err
+		}
+		mu.Lock()
+		if len(columns) == 0 {
+			columns = table.Columns
+		}
+		mu.Unlock()
+		return namespacePage[TableRowResource]{
+			items: table.Rows,
+			meta:  metav1.ListMeta{Continue: table.Continue, RemainingItemCount: table.RemainingItemCount},
+		}, nil
+	})
+	return &TableResource{
+		Columns:            columns,
+		Rows:               page.items,
+		Errors:             errs,
+		LabelSelector:      request.opts.LabelSelector,
+		FieldSelector:      request.opts.FieldSelector,
+		Continue:           page.meta.Continue,
+		RemainingItemCount: page.meta.RemainingItemCount,
+	}, ok
+}
+
+// getTable lists resource in namespace as a server-side Table and merges the
+// dashboard's own columns (labels, resourceType) into every row.
+func getTable(restClient rest.Interface, resource, namespace string, opts metav1.ListOptions, resourceType string) (*TableResource, error) {
+	raw, err := restClient.Get().
+		Namespace(namespace).
+		Resource(resource).
+		VersionedParams(&opts, metav1.ParameterCodec).
+		Param("includeObject", string(metav1.IncludeMetadata)).
+		SetHeader("Accept", tableAcceptHeader).
+		Do(context.TODO()).
+		Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	var table metav1.Table
+	if err := json.Unmarshal(raw, &table); err != nil {
+		return nil, fmt.Errorf("error decoding table response: %v", err)
+	}
+
+	result := &TableResource{
+		Columns:            make([]TableColumn, 0, len(table.ColumnDefinitions)),
+		Rows:               make([]TableRowResource, 0, len(table.Rows)),
+		Continue:           table.Continue,
+		RemainingItemCount: table.RemainingItemCount,
+	}
+	for _, col := range table.ColumnDefinitions {
+		result.Columns = append(result.Columns, TableColumn{
+			Name:        col.Name,
+			Type:        col.Type,
+			Format:      col.Format,
+			Description: col.Description,
+			Priority:    col.Priority,
+		})
+	}
+
+	for _, row := range table.Rows {
+		// With includeObject=Metadata every row carries the object's metadata,
+		// which is where name, namespace and labels come from.
+		var meta metav1.PartialObjectMetadata
+		if len(row.Object.Raw) > 0 {
+			if err := json.Unmarshal(row.Object.Raw, &meta); err != nil {
+				return nil, fmt.Errorf("error decoding table row metadata: %v", err)
+			}
+		}
+
+		cells := make(map[string]interface{}, len(row.Cells))
+		for i, cell := range row.Cells {
+			if i < len(table.ColumnDefinitions) {
+				cells[table.ColumnDefinitions[i].Name] = cell
+			}
+		}
+
+		result.Rows = append(result.Rows, TableRowResource{
+			Name:         meta.Name,
+			Namespace:    meta.Namespace,
+			Labels:       meta.Labels,
+			ResourceType: resourceType,
+			Cells:        cells,
+			createdAt:    meta.CreationTimestamp.Time,
+			restarts:     tableRestarts(cells["Restarts"]),
+		})
+	}
+
+	return result, nil
+}
+
+// tableRestarts reads the Restarts column of a pod Table, which is a number,
+// or a string like "3 (5m ago)" when the last restart is known
+func tableRestarts(cell interface{}) int32 {
+	switch value := cell.(type) {
+	case float64:
+		return int32(value)
+	case string:
+		if fields := strings.Fields(value); len(fields) > 0 {
+			if restarts, err := strconv.ParseInt(fields[0], 10, 32); err == nil {
+				return int32(restarts)
+			}
+		}
+	}
+	return 0
+}
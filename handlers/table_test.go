@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTableRestarts(t *testing.T) {
+	tests := []struct {
+		cell interface{}
+		want int32
+	}{
+		{cell: float64(4), want: 4},
+		{cell: "3 (5m ago)", want: 3},
+		{cell: "12", want: 12},
+		{cell: "", want: 0},
+		{cell: "unknown", want: 0},
+		{cell: nil, want: 0},
+	}
+	for _, test := range tests {
+		if got := tableRestarts(test.cell); got != test.want {
+			t.Errorf("tableRestarts(%#v) = %d, want %d", test.cell, got, test.want)
+		}
+	}
+}
+
+func TestGetPodsTableSort(t *testing.T) {
+	// A pod Table as the API server prints it, with the metadata of each row
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"Table","apiVersion":"meta.k8s.io/v1",
+			"columnDefinitions":[{"name":"Name","type":"string"},{"name":"Restarts","type":"string"}],
+			"rows":[
+				{"cells":["web-a","1 (2m ago)"],"object":{"kind":"PartialObjectMetadata","apiVersion":"meta.k8s.io/v1","metadata":{"name":"web-a","namespace":"default"}}},
+				{"cells":["web-b",7],"object":{"kind":"PartialObjectMetadata","apiVersion":"meta.k8s.io/v1","metadata":{"name":"web-b","namespace":"default"}}},
+				{"cells":["web-c",0],"object":{"kind":"PartialObjectMetadata","apiVersion":"meta.k8s.io/v1","metadata":{"name":"web-c","namespace":"default"}}}
+			]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	sessionToken := testSession(t, server.URL)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       []string
+	}{
+		{name: "by name", query: "sortBy=name", wantStatus: http.StatusOK, want: []string{"web-a", "web-b", "web-c"}},
+		{name: "by restarts", query: "sortBy=restarts", wantStatus: http.StatusOK, want: []string{"web-c", "web-a", "web-b"}},
+		{name: "by restarts descending", query: "sortBy=restarts&order=desc", wantStatus: http.StatusOK, want: []string{"web-b", "web-a", "web-c"}},
+		{name: "by cpu", query: "sortBy=cpu", wantStatus: http.StatusBadRequest},
+		{name: "by memory", query: "sortBy=memory", wantStatus: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/pods/namespace/:namespace", GetPods)
+			request := httptest.NewRequest(http.MethodGet, "/pods/namespace/default?format=table&"+test.query, nil)
+			request.AddCookie(&http.Cookie{Name: "sessionToken", Value: sessionToken})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			if w.Code != test.wantStatus {
+				t.Fatalf("got %d: %s", w.Code, w.Body.String())
+			}
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+
+			var table TableResource
+			if err := json.Unmarshal(w.Body.Bytes(), &table); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, row := range table.Rows {
+				got = append(got, row.Name)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// tokenLoginInsecureEnv allows token logins that skip verifying the API
+// server's certificate when set to "true". It is off by default.
+const tokenLoginInsecureEnv = "TOKEN_LOGIN_ALLOW_INSECURE"
+
+// tokenLoginUser names the kubeconfig user until the API server says who the
+// token belongs to
+const tokenLoginUser = "token"
+
+// TokenLoginRequest logs in with a bearer token, such as a service account
+// token. CertificateAuthority is the PEM bundle of the API server's CA.
+type TokenLoginRequest struct {
+	Server               string `json:"server"`
+	CertificateAuthority string `json:"certificateAuthority"`
+	Token                string `json:"token"`
+	Insecure             bool   `json:"insecure"`
+}
+
+// TokenLogin starts a session from an API server URL, CA bundle and bearer
+// token, for users without a kubeconfig file. A kubeconfig is built from them
+// so the session works like one from UploadKubeConfig.
+func TokenLogin(c *gin.Context) {
+	if authMode() == authModeInCluster {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token login is disabled in in-cluster mode"})
+		return
+	}
+	var request TokenLoginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login: " + err.Error()})
+		return
+	}
+	if err := validateTokenLogin(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login: " + err.Error()})
+		return
+	}
+
+	kubeconfig := tokenKubeconfig(request, tokenLoginUser)
+	kubeconfigBytes, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build kubeconfig: " + err.Error()})
+		return
+	}
+	clientset, err := validateKubeConfig(string(kubeconfigBytes), "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login: " + err.Error()})
+		return
+	}
+	if err := checkCredentials(clientset); apierrors.IsUnauthorized(err) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid login: the API server does not accept the token"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to interact with cluster: " + err.Error()})
+		return
+	}
+
+	// The kubeconfig user is named after whoever the token authenticates as
+	identity := kubeconfigIdentity(clientset, string(kubeconfigBytes), "", tokenLoginUser)
+	if identity.Username != tokenLoginUser {
+		kubeconfig = tokenKubeconfig(request, identity.Username)
+		if kubeconfigBytes, err = clientcmd.Write(*kubeconfig); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build kubeconfig: " + err.Error()})
+			return
+		}
+	}
+
+	if existingToken, err := c.Cookie("sessionToken"); err == nil {
+		deleteSession(existingToken)
+		forgetPermissions(existingToken)
+	}
+	sessionToken := newSessionToken()
+	session := SessionData{
+		KubeconfigContent: string(kubeconfigBytes),
+		Context:           kubeconfig.CurrentContext,
+		Username:          identity.Username,
+		Groups:            identity.Groups,
+		UID:               identity.UID,
+		CreatedAt:         time.Now(),
+	}
+	// Bound service account tokens expire, legacy ones do not
+	if err := capSessionExpiry(&session); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login: " + err.Error()})
+		return
+	}
+	saveSession(sessionToken, session)
+	c.SetCookie("sessionToken", sessionToken, sessionCookieMaxAge(session), "/", "", false, true)
+
+	var warnings []string
+	if warning := credentialWarning(session); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Token validated successfully",
+		"user":     identity.Username,
+		"groups":   identity.Groups,
+		"context":  kubeconfig.CurrentContext,
+		"warnings": warnings,
+	})
+}
+
+func validateTokenLogin(request TokenLoginRequest) error {
+	server, err := url.Parse(request.Server)
+	if err != nil || server.Scheme != "https" || server.Host == "" {
+		return fmt.Errorf("server must be an https URL")
+	}
+	if request.Token == "" {
+		return fmt.Errorf("token is required")
+	}
+	if request.Insecure {
+		if os.Getenv(tokenLoginInsecureEnv) != "true" {
+			return fmt.Errorf("insecure logins are not allowed on this dashboard")
+		}
+		return nil
+	}
+	if request.CertificateAuthority == "" {
+		return fmt.Errorf("certificateAuthority is required")
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM([]byte(request.CertificateAuthority)) {
+		return fmt.Errorf("certificateAuthority holds no PEM certificate")
+	}
+	return nil
+}
+
+// tokenKubeconfig builds a single-context kubeconfig for a token login. The
+// context is named user@host, like the kubeconfigs kubeadm writes.
+func tokenKubeconfig(request TokenLoginRequest, user string) *clientcmdapi.Config {
+	server, _ := url.Parse(request.Server)
+	clusterName := server.Host
+	contextName := user + "@" + clusterName
+
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[clusterName] = &clientcmdapi.Cluster{
+		Server:                   request.Server,
+		CertificateAuthorityData: []byte(request.CertificateAuthority),
+		InsecureSkipTLSVerify:    request.Insecure,
+	}
+	if request.Insecure {
+		kubeconfig.Clusters[clusterName].CertificateAuthorityData = nil
+	}
+	kubeconfig.AuthInfos[user] = &clientcmdapi.AuthInfo{Token: request.Token}
+	kubeconfig.Contexts[contextName] = &clientcmdapi.Context{Cluster: clusterName, AuthInfo: user}
+	kubeconfig.CurrentContext = contextName
+	return kubeconfig
+}
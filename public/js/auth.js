@@ -8,9 +8,58 @@ document.getElementById('loginForm').onsubmit = async function(event) {
         body: formData
     });
 
+    const data = await response.json().catch(() => ({}));
+    if (response.ok) {
+        if (data.warnings && data.warnings.length > 0) alert(data.warnings.join('\n'));
+        window.location.href = '/dashboard';
+    } else {
+        alert(data.error || 'Authentication failed');
+    }
+};
+// In in-cluster mode the authenticating proxy has already identified the user,
+// so log in straight away instead of asking for a kubeconfig
+async function loginInCluster() {
+    const modeResponse = await fetch('/api/v1/authmode');
+    if (!modeResponse.ok) return;
+    const authMode = await modeResponse.json();
+    document.getElementById('oidcLogin').style.display = authMode.oidc ? '' : 'none';
+    if (authMode.mode !== 'incluster') return;
+
+    document.getElementById('loginForm').style.display = 'none';
+    document.getElementById('showTokenLogin').style.display = 'none';
+    const response = await fetch('/api/v1/login', { method: 'POST' });
     if (response.ok) {
         window.location.href = '/dashboard';
     } else {
         alert('Authentication failed');
     }
-};
\ No newline at end of file
+}
+
+document.getElementById('showTokenLogin').onclick = function(event) {
+    event.preventDefault();
+    document.getElementById('tokenLoginForm').style.display = '';
+};
+
+document.getElementById('tokenLoginForm').onsubmit = async function(event) {
+    event.preventDefault();
+    const response = await fetch('/api/v1/login/token', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            server: document.getElementById('tokenServer').value.trim(),
+            certificateAuthority: document.getElementById('tokenCA').value.trim(),
+            token: document.getElementById('tokenValue').value.trim(),
+            insecure: document.getElementById('tokenInsecure').checked
+        })
+    });
+
+    const data = await response.json().catch(() => ({}));
+    if (response.ok) {
+        if (data.warnings && data.warnings.length > 0) alert(data.warnings.join('\n'));
+        window.location.href = '/dashboard';
+    } else {
+        alert(data.error || 'Authentication failed');
+    }
+};
+
+loginInCluster();
@@ -7,6 +7,8 @@ async function checkAuthentication() {
             return null;
         }
         const data = await response.json();
+        showCredentialWarning(data.credentialWarning);
+        watchSessionExpiry();
         return data.user; // Get username from response
     } catch (error) {
         window.location.href = '/';
@@ -19,4 +21,54 @@ function displayUsername(username) {
     if (usernameElement && username) {
         usernameElement.textContent = username;
     }
-}
\ No newline at end of file
+}
+// Warn once per browser session that the credentials are about to expire
+function showCredentialWarning(warning) {
+    if (!warning || sessionStorage.getItem("credentialWarning") === warning) return;
+    sessionStorage.setItem("credentialWarning", warning);
+    alert(warning);
+}
+// Poll the session's remaining lifetime and offer to extend it shortly before
+// it ends. Polling authcheck does not count as activity, so it does not keep
+// an idle session alive by itself.
+const sessionWarningSeconds = 5 * 60;
+let sessionWatch = null;
+let sessionWarned = false;
+function watchSessionExpiry() {
+    if (sessionWatch) return;
+    sessionWatch = setInterval(checkSessionExpiry, 60 * 1000);
+}
+async function checkSessionExpiry() {
+    try {
+        const response = await fetch('/api/v1/authcheck');
+        if (!response.ok) {
+            window.location.href = '/';
+            return;
+        }
+        const data = await response.json();
+        if (data.remainingSeconds > sessionWarningSeconds) {
+            sessionWarned = false;
+            return;
+        }
+        if (sessionWarned) return;
+        sessionWarned = true;
+        const minutes = Math.max(1, Math.round(data.remainingSeconds / 60));
+        if (!data.renewable) {
+            alert(`Your session ends in ${minutes} minute(s) and cannot be extended, please save your work and log in again.`);
+            return;
+        }
+        if (confirm(`Your session expires in ${minutes} minute(s). Stay logged in?`)) {
+            await refreshSession();
+        }
+    } catch (error) {
+        console.error('Error checking session:', error);
+    }
+}
+async function refreshSession() {
+    const response = await fetch('/api/v1/session/refresh', { method: 'POST' });
+    if (!response.ok) {
+        window.location.href = '/';
+        return;
+    }
+    sessionWarned = false;
+}
@@ -1,12 +1,35 @@
 // dashboard.js
 const elements = {
     namespace: document.getElementById("namespace"),
+    kubeContext: document.getElementById("kubeContext"),
     resourceType: document.getElementById("resourceType"),
+    tableFormat: document.getElementById("tableFormat"),
+    allClusters: document.getElementById("allClusters"),
+    labelSearch: document.getElementById("labelSearch"),
+    loadMoreButton: document.getElementById("loadMoreButton"),
+    nodeActions: document.getElementById("nodeActions"),
+    drainProgress: document.getElementById("drainProgress"),
+    restartProgress: document.getElementById("restartProgress"),
+    rolloutRestartButton: document.getElementById("rolloutRestartButton"),
+    cordonButton: document.getElementById("cordonButton"),
+    uncordonButton: document.getElementById("uncordonButton"),
+    drainButton: document.getElementById("drainButton"),
     hotContainer: document.getElementById("hot-container")
 };
 
+// Route value the API accepts for listing every namespace
+const ALL_NAMESPACES = "_all";
+// Items fetched per page; the rest is loaded on demand
+const PAGE_SIZE = 500;
+
 let hot;
 let resourceData = [];
+// Actions the session may perform in the selected namespace, by action name
+let permissions = {};
+// Continue token of the last page fetched, null once everything is loaded
+let nextPage = null;
+// What double-clicking a row does in the current view, if anything
+let openRow = null;
 
 // UI Object
 const ui = {
@@ -20,6 +43,10 @@ const ui = {
             namespaceSelect.appendChild(option);
             return;
         }
+        const allOption = document.createElement("option");
+        allOption.value = ALL_NAMESPACES;
+        allOption.textContent = "All namespaces";
+        namespaceSelect.appendChild(allOption);
         namespaces.forEach(namespace => {
             const option = document.createElement("option");
             option.value = namespace;
@@ -29,39 +56,63 @@ const ui = {
     }
 };
 
+const defaultColHeaders = ['Select', 'Namespace', 'Type', 'Name', 'Labels', 'Ready', 'Up-to-date', 'Age', 'CPU', 'Memory', 'HPA'];
+const defaultColumns = [
+    {
+        type: 'checkbox',
+        className: 'checkbox-header',
+        width: 50,
+        data: 'selected'
+    },
+    { data: 'namespace', width: 120 },
+    { data: 'resourceType', width: 100 },
+    { data: 'name' },
+    {
+        data: 'labels',
+        renderer: labelsRenderer,
+        filter: {
+            type: 'multi_select',
+            options: getLabelFilterOptions
+        }
+    },
+    { data: 'ready', width: 100 },
+    { data: 'up_to_date', width: 100 },
+    { data: 'age', width: 80 },
+    { data: row => formatUsage(row.usage, 'cpu'), readOnly: true, width: 120 },
+    { data: row => formatUsage(row.usage, 'memory'), readOnly: true, width: 120 },
+    { data: row => row.hpa || '', readOnly: true, width: 120 }
+];
+
+// Usage from metrics-server, with the percentage of the request when there is one
+function formatUsage(usage, resource) {
+    if (!usage) return '';
+    const percent = usage[`${resource}PercentOfRequest`] ?? usage[`${resource}PercentOfAllocatable`];
+    return percent !== undefined ? `${usage[resource]} (${percent}%)` : usage[resource];
+}
+
 // Initialize Handsontable
 function initializeHandsontable() {
     hot = new Handsontable(elements.hotContainer, {
         data: resourceData,
-        colHeaders: ['Select', 'Namespace', 'Type', 'Name', 'Labels', 'Ready', 'Up-to-date', 'Age'],
-        columns: [
-            {
-                type: 'checkbox',
-                className: 'checkbox-header',
-                width: 50,
-                data: 'selected'
-            },
-            { data: 'namespace', width: 120 },
-            { data: 'resourceType', width: 100 },
-            { data: 'name' },
-            {
-                data: 'labels',
-                renderer: labelsRenderer,
-                filter: {
-                    type: 'multi_select',
-                    options: getLabelFilterOptions
-                }
-            },
-            { data: 'ready', width: 100 },
-            { data: 'up_to_date', width: 100 },
-            { data: 'age', width: 80 }
-        ],
+        colHeaders: defaultColHeaders,
+        columns: defaultColumns,
         filters: true,
         dropdownMenu: true,
         width: '100%',
         height: '100%',
         rowHeaders: true,
         licenseKey: 'non-commercial-and-evaluation',
+        afterOnCellMouseDown: (event, coords) => {
+            if (event.detail === 2 && openRow && coords.row >= 0) {
+                const row = resourceData[hot.toPhysicalRow(coords.row)];
+                // Details are fetched from the active context only
+                if (row.cluster && row.cluster !== elements.kubeContext.value) {
+                    alert(`Switch to ${row.cluster} to open this row`);
+                    return;
+                }
+                openRow(row);
+            }
+        },
         afterChange: (changes, source) => {
             if (source === 'edit' && changes) {
                 const [row, prop, oldValue, newValue] = changes[0];
@@ -77,6 +128,17 @@ function initializeHandsontable() {
 function setupEventListeners() {
     document.getElementById("searchButton").addEventListener("click", handleSearch);
     document.getElementById("rolloutRestartButton").addEventListener("click", performRolloutRestart);
+    elements.loadMoreButton.addEventListener("click", handleLoadMore);
+    document.getElementById("cordonButton").addEventListener("click", () => performNodeAction("cordon"));
+    document.getElementById("uncordonButton").addEventListener("click", () => performNodeAction("uncordon"));
+    document.getElementById("drainButton").addEventListener("click", performDrain);
+    elements.kubeContext.addEventListener("change", () => switchContext(elements.kubeContext.value));
+    elements.resourceType.addEventListener("change", () => {
+        elements.nodeActions.style.display = elements.resourceType.value === "node" ? "" : "none";
+    });
+
+    document.getElementById('addKubeconfigButton').addEventListener('click', () => document.getElementById('addKubeconfig').click());
+    document.getElementById('addKubeconfig').addEventListener('change', addKubeconfig);
 
     document.getElementById('logoutButton').addEventListener('click', async function() {
         const response = await fetch('/logout', { method: 'POST' });
@@ -91,19 +153,41 @@ function setupEventListeners() {
 async function handleSearch() {
     const namespace = elements.namespace.value.trim();
     const resourceType = elements.resourceType.value;
+    openRow = null;
+    await loadPermissions(namespace || ALL_NAMESPACES);
+    // Actions only reach the active context, so they are off across clusters
+    if (elements.allClusters.checked) elements.rolloutRestartButton.disabled = true;
+
+    // Nodes are cluster-scoped, so no namespace is needed
+    if (resourceType === "node") {
+        await handleNodeSearch();
+        return;
+    }
+    if (clusterViews[resourceType]) {
+        await handleViewSearch(null, clusterViews[resourceType]);
+        return;
+    }
     
     if (!namespace) {
         alert("Please select a namespace.");
         return;
     }
 
+    if (namespacedViews[resourceType]) {
+        await handleViewSearch(namespace, namespacedViews[resourceType]);
+        return;
+    }
+
+    if (elements.tableFormat.checked) {
+        await handleTableSearch(namespace, resourceType);
+        return;
+    }
+
     try {
-        const data = await fetchResources(namespace, resourceType);
-        resourceData = data.map(item => ({
-            ...item,
-            selected: false,
-            labels: Object.entries(item.labels || {}).map(([k, v]) => `${k}:${v}`)
-        }));
+        const data = await fetchResources(namespace, resourceType, { limit: PAGE_SIZE });
+        hot.updateSettings(withClusterColumn({ colHeaders: defaultColHeaders, columns: defaultColumns }));
+        resourceData = data.map(item => ({ ...item, selected: false }));
+        openRow = showOwnershipTree;
         updateHandsontable();
         applyStatusHighlighting();
     } catch (error) {
@@ -112,13 +196,597 @@ async function handleSearch() {
     }
 }
 
-async function fetchResources(namespace, resourceType) {
+// Append the next page of the current search
+// Disables the actions RBAC would refuse, so users find out before clicking
+async function loadPermissions(namespace) {
+    try {
+        const response = await fetch(`/api/v1/permissions/namespace/${namespace}`);
+        if (!response.ok) throw new Error("Error fetching permissions");
+        permissions = (await response.json()).actions;
+    } catch (error) {
+        // Without permissions leave everything enabled; the API still enforces RBAC
+        console.error("Permissions error:", error);
+        permissions = {};
+    }
+    const allowed = action => permissions[action] !== false;
+    elements.rolloutRestartButton.disabled = !['deployments.restart', 'statefulsets.restart', 'pods.delete'].some(allowed);
+    elements.cordonButton.disabled = !allowed('nodes.cordon');
+    elements.uncordonButton.disabled = !allowed('nodes.cordon');
+    elements.drainButton.disabled = !allowed('nodes.cordon') || !allowed('nodes.drain');
+}
+
+async function handleLoadMore() {
+    const namespace = elements.namespace.value.trim();
+    const resourceType = elements.resourceType.value;
+    if (!nextPage) return;
+
+    try {
+        const data = await fetchResources(namespace, resourceType, { limit: PAGE_SIZE, continue: nextPage });
+        resourceData = resourceData.concat(data.map(item => ({ ...item, selected: false })));
+        updateHandsontable();
+        applyStatusHighlighting();
+    } catch (error) {
+        console.error("Load more error:", error);
+        alert("Error fetching resources");
+    }
+}
+
+async function fetchResources(namespace, resourceType, extra = {}) {
     const endpoints = {
         deployment: fetchDeployments,
         statefulset: fetchStatefulSets,
         pod: fetchPods
     };
-    return endpoints[resourceType] ? await endpoints[resourceType](namespace) : [];
+    setNextPage(null);
+    return endpoints[resourceType] ? await endpoints[resourceType](namespace, extra) : [];
+}
+
+function setNextPage(token) {
+    nextPage = token || null;
+    elements.loadMoreButton.style.display = nextPage ? "" : "none";
+}
+
+// Search using the API server's Table format so the grid shows kubectl's columns
+async function handleTableSearch(namespace, resourceType) {
+    setNextPage(null);
+    try {
+        const table = await fetchTable(namespace, resourceType);
+        // Only the default (priority 0) columns, as in `kubectl get` without -o wide
+        const tableColumns = table.columns.filter(column => column.priority === 0);
+
+        resourceData = table.rows.map(row => ({
+            ...row,
+            selected: false,
+            labels: transformLabels(row.labels)
+        }));
+        hot.updateSettings({
+            colHeaders: ['Select', 'Namespace', 'Type', ...tableColumns.map(column => column.name), 'Labels'],
+            columns: [
+                defaultColumns[0],
+                { data: 'namespace', width: 120 },
+                { data: 'resourceType', width: 100 },
+                ...tableColumns.map(column => ({ data: row => (row.cells || {})[column.name], readOnly: true })),
+                defaultColumns[4]
+            ]
+        });
+        updateHandsontable();
+    } catch (error) {
+        console.error("Search error:", error);
+        alert("Error fetching resources");
+    }
+}
+
+async function fetchTable(namespace, resourceType) {
+    const response = await fetch(`/api/v1/${resourceType}s/namespace/${namespace}${listQuery({ format: 'table' })}`);
+    if (!response.ok) {
+        await reportBadRequest(response);
+        throw new Error(`Error fetching ${resourceType}s`);
+    }
+    const data = await response.json();
+    reportNamespaceErrors(data.errors, data.clusterErrors);
+    return {
+        columns: data.columns || [],
+        rows: data.rows || []
+    };
+}
+
+const nodeColHeaders = ['Select', 'Name', 'Status', 'Roles', 'Version', 'OS/Arch', 'CPU Requests', 'Memory Requests', 'CPU', 'Memory', 'Pods', 'Taints', 'Age'];
+const nodeColumns = [
+    defaultColumns[0],
+    { data: 'name' },
+    { data: 'status', width: 120 },
+    { data: row => (row.roles || []).join(','), readOnly: true },
+    { data: 'kubeletVersion', width: 100 },
+    { data: row => `${row.os}/${row.architecture}`, readOnly: true },
+    { data: row => row.podsUnknown ? `? / ${row.allocatable.cpu}` : `${row.requested.cpu} / ${row.allocatable.cpu} (${row.cpuRequestedPercent}%)`, readOnly: true },
+    { data: row => row.podsUnknown ? `? / ${row.allocatable.memory}` : `${row.requested.memory} / ${row.allocatable.memory} (${row.memoryRequestedPercent}%)`, readOnly: true },
+    { data: row => formatUsage(row.usage, 'cpu'), readOnly: true },
+    { data: row => formatUsage(row.usage, 'memory'), readOnly: true },
+    { data: row => row.podsUnknown ? '?' : row.podCount, readOnly: true, width: 60 },
+    { data: row => (row.taints || []).join(', '), readOnly: true },
+    { data: 'age', width: 80 }
+];
+
+// Nodes have their own columns: health, versions and requested capacity
+async function handleNodeSearch() {
+    setNextPage(null);
+    try {
+        const response = await fetch(`/api/v1/nodes${listQuery()}`);
+        if (!response.ok) {
+            await reportBadRequest(response);
+            throw new Error("Error fetching nodes");
+        }
+        const data = await response.json();
+        reportNamespaceErrors(data.errors, data.clusterErrors);
+        resourceData = (data.items || []).map(item => ({
+            ...item,
+            selected: false,
+            labels: transformLabels(item.labels)
+        }));
+        hot.updateSettings(withClusterColumn({ colHeaders: nodeColHeaders, columns: nodeColumns }));
+        updateHandsontable();
+    } catch (error) {
+        console.error("Search error:", error);
+        alert("Error fetching nodes");
+    }
+}
+
+function selectedNodes() {
+    return resourceData.filter(row => row.resourceType === "Node" && row.selected).map(row => row.name);
+}
+
+async function performNodeAction(action) {
+    const nodes = selectedNodes();
+    if (nodes.length === 0) {
+        alert("Please select nodes");
+        return;
+    }
+    if (!confirm(`${action} ${nodes.length} selected nodes?`)) return;
+
+    const results = await Promise.all(nodes.map(async name => {
+        try {
+            const response = await fetch(`/api/v1/nodes/${name}/${action}`, { method: 'POST' });
+            return response.ok;
+        } catch (error) {
+            console.error(`${action} failed:`, error);
+            return false;
+        }
+    }));
+    alert(`Successfully ran ${action} on ${results.filter(ok => ok).length}/${nodes.length} nodes`);
+    await handleNodeSearch();
+}
+
+// Drain one node at a time, following the job's progress stream
+async function performDrain() {
+    const nodes = selectedNodes();
+    if (nodes.length !== 1) {
+        alert("Please select exactly one node to drain");
+        return;
+    }
+    const deleteEmptyDirData = confirm("Delete emptyDir data of pods on the node? (Cancel keeps the drain from evicting them)");
+    if (!confirm(`Drain node ${nodes[0]}?`)) return;
+
+    const response = await fetch(`/api/v1/nodes/${nodes[0]}/drain`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ deleteEmptyDirData })
+    });
+    if (!response.ok) {
+        alert("Failed to start drain");
+        return;
+    }
+    const { id } = await response.json();
+
+    const events = new EventSource(`/api/v1/drains/${id}/stream`);
+    events.addEventListener("progress", event => {
+        const job = JSON.parse(event.data);
+        elements.drainProgress.textContent = `Draining ${job.node}: ${job.evicted.length} evicted, ` +
+            `${job.terminating.length} terminating, ${job.pending.length} pending, ${job.blockedByPDB.length} blocked by PDB`;
+        if (job.status !== "Running") {
+            events.close();
+            elements.drainProgress.textContent = "";
+            alert(job.status === "Succeeded" ? `Drained ${job.node}` : `Drain of ${job.node} failed: ${job.error}`);
+            handleNodeSearch();
+        }
+    });
+    events.onerror = () => events.close();
+}
+
+// Namespaced kinds that have their own columns instead of the workload ones
+const namespacedViews = {
+    summary: {
+        path: 'summaries',
+        colHeaders: ['Namespace', 'Status', 'Deployments', 'StatefulSets', 'DaemonSets', 'Pods', 'Containers Restarted (1h)', 'Warnings', 'Quotas', 'Labels'],
+        columns: [
+            { data: 'name', width: 140 },
+            { data: row => [row.status, ...(row.warnings || []).map(warning => `⚠ ${warning}`)].filter(Boolean).join('\n'), readOnly: true, width: 80 },
+            { data: row => formatWorkloadHealth(row.workloads.Deployment), readOnly: true },
+            { data: row => formatWorkloadHealth(row.workloads.StatefulSet), readOnly: true },
+            { data: row => formatWorkloadHealth(row.workloads.DaemonSet), readOnly: true },
+            { data: row => Object.entries(row.pods).map(([phase, count]) => `${phase}: ${count}`).join(', '), readOnly: true },
+            { data: 'containersRestartedLastHour', width: 90 },
+            { data: 'warningEvents', width: 80 },
+            { data: row => (row.quotas || []).flatMap(q => q.resources.map(r => `${r.warning ? '⚠ ' : ''}${r.resource} ${r.percent}%`)).join(', '), readOnly: true },
+            { data: 'labels', renderer: labelsRenderer }
+        ],
+        isUnhealthy: row => !row.healthy
+    },
+    service: {
+        path: 'services',
+        colHeaders: ['Namespace', 'Name', 'Type', 'Cluster IP', 'Ports', 'Endpoints', 'Age'],
+        columns: [
+            { data: 'namespace', width: 120 },
+            { data: 'name' },
+            { data: 'type', width: 100 },
+            { data: 'clusterIP', width: 120 },
+            { data: row => (row.ports || []).join(', '), readOnly: true },
+            { data: row => `${row.readyEndpoints} ready, ${row.notReadyEndpoints} not ready`, readOnly: true },
+            { data: 'age', width: 80 }
+        ],
+        // A selector that matches no ready pods means the service is down
+        isUnhealthy: row => row.noReadyBackends
+    },
+    ingress: {
+        path: 'ingresses',
+        colHeaders: ['Namespace', 'Name', 'Class', 'Hosts', 'Backends', 'TLS Secrets', 'Address', 'Age'],
+        columns: [
+            { data: 'namespace', width: 120 },
+            { data: 'name' },
+            { data: 'class', width: 100 },
+            { data: row => (row.hosts || []).join(', '), readOnly: true },
+            { data: row => (row.paths || []).map(p => `${p.host}${p.path} → ${p.service || p.resource}:${p.port || ''}`).join('\n'), readOnly: true },
+            { data: row => (row.tls || []).map(t => t.secretName).join(', '), readOnly: true },
+            { data: row => (row.addresses || []).join(', '), readOnly: true },
+            { data: 'age', width: 80 }
+        ],
+        isUnhealthy: () => false
+    },
+    configmap: {
+        path: 'configmaps',
+        colHeaders: ['Namespace', 'Name', 'Keys', 'Used By', 'Age'],
+        columns: [
+            { data: 'namespace', width: 120 },
+            { data: 'name' },
+            { data: row => formatKeys(row.keys), readOnly: true },
+            { data: row => formatUsedBy(row.usedBy), readOnly: true },
+            { data: 'age', width: 80 }
+        ],
+        isUnhealthy: () => false,
+        open: async row => {
+            await showConfigMap(row);
+            await restartConsumers('configmaps', row);
+        }
+    },
+    secret: {
+        path: 'secrets',
+        colHeaders: ['Namespace', 'Name', 'Type', 'Keys', 'Used By', 'Age'],
+        columns: [
+            { data: 'namespace', width: 120 },
+            { data: 'name' },
+            { data: 'type', width: 160 },
+            { data: row => formatKeys(row.keys), readOnly: true },
+            { data: row => formatUsedBy(row.usedBy), readOnly: true },
+            { data: 'age', width: 80 }
+        ],
+        isUnhealthy: () => false,
+        open: async row => {
+            await revealSecret(row);
+            await restartConsumers('secrets', row);
+        }
+    },
+    hpa: {
+        path: 'hpas',
+        colHeaders: ['Namespace', 'Name', 'Target', 'Min', 'Max', 'Replicas', 'Metrics', 'Conditions', 'Age'],
+        columns: [
+            { data: 'namespace', width: 120 },
+            { data: 'name' },
+            { data: row => `${row.targetKind}/${row.targetName}`, readOnly: true },
+            { data: 'minReplicas', width: 60 },
+            { data: 'maxReplicas', width: 60 },
+            { data: row => `${row.currentReplicas} → ${row.desiredReplicas}`, readOnly: true, width: 90 },
+            { data: row => (row.metrics || []).map(m => `${m.name}: ${m.current}/${m.target}`).join('\n'), readOnly: true },
+            { data: row => (row.conditions || []).filter(c => c.status !== 'True' || c.type === 'ScalingLimited').map(c => `${c.type}=${c.status} ${c.reason || ''}`).join('\n'), readOnly: true },
+            { data: 'age', width: 80 }
+        ],
+        isUnhealthy: row => (row.conditions || []).some(c => (c.type === 'AbleToScale' || c.type === 'ScalingActive') && c.status === 'False'),
+        open: editHPAReplicas
+    },
+    quota: {
+        path: 'resourcequotas',
+        colHeaders: ['Namespace', 'Name', 'Usage', 'Scopes', 'Age'],
+        columns: [
+            { data: 'namespace', width: 120 },
+            { data: 'name' },
+            { data: row => row.resources.map(r => `${r.warning ? '⚠ ' : ''}${r.resource}: ${r.used}/${r.hard} (${r.percent}%)`).join('\n'), readOnly: true },
+            { data: row => (row.scopes || []).join(', '), readOnly: true },
+            { data: 'age', width: 80 }
+        ],
+        isUnhealthy: row => row.warning
+    },
+    limitrange: {
+        path: 'limitranges',
+        colHeaders: ['Namespace', 'Name', 'Limits', 'Age'],
+        columns: [
+            { data: 'namespace', width: 120 },
+            { data: 'name' },
+            { data: row => row.limits.map(formatLimit).join('\n'), readOnly: true },
+            { data: 'age', width: 80 }
+        ],
+        isUnhealthy: () => false
+    },
+    pvc: {
+        path: 'persistentvolumeclaims',
+        colHeaders: ['Namespace', 'Name', 'Status', 'Volume', 'Capacity', 'Access Modes', 'Storage Class', 'Mounted By', 'Orphaned', 'Age'],
+        columns: [
+            { data: 'namespace', width: 120 },
+            { data: 'name' },
+            { data: 'status', width: 80 },
+            { data: 'volume' },
+            { data: 'capacity', width: 80 },
+            { data: row => (row.accessModes || []).join(', '), readOnly: true, width: 90 },
+            { data: 'storageClass', width: 120 },
+            { data: row => (row.mountedBy || []).join(', '), readOnly: true },
+            { data: row => (row.possiblyOrphaned ? 'Possibly: ' : '') + (row.orphanReason || ''), readOnly: true },
+            { data: 'age', width: 80 }
+        ],
+        isUnhealthy: row => row.notBound || row.orphaned
+    }
+};
+
+// Cluster-scoped views are listed without a namespace
+const clusterViews = {
+    pv: {
+        path: 'persistentvolumes',
+        clusterScoped: true,
+        colHeaders: ['Name', 'Status', 'Claim', 'Capacity', 'Access Modes', 'Reclaim Policy', 'Storage Class', 'Age'],
+        columns: [
+            { data: 'name' },
+            { data: 'status', width: 80 },
+            { data: 'claim' },
+            { data: 'capacity', width: 80 },
+            { data: row => (row.accessModes || []).join(', '), readOnly: true, width: 90 },
+            { data: 'reclaimPolicy', width: 100 },
+            { data: 'storageClass', width: 120 },
+            { data: 'age', width: 80 }
+        ],
+        isUnhealthy: row => row.status === 'Failed' || row.status === 'Released'
+    }
+};
+
+// Shows what a workload owns, down to the pods, so a "Not Ready" row can be
+// traced to the failing pods
+async function showOwnershipTree(row) {
+    if (row.resourceType !== 'Deployment' && row.resourceType !== 'StatefulSet') return;
+    const response = await fetch(`/api/v1/${row.resourceType.toLowerCase()}s/${row.namespace}/tree/${row.name}`);
+    const tree = await response.json();
+    if (!response.ok) {
+        alert(tree.error || "Error fetching ownership tree");
+        return;
+    }
+    alert(formatOwnershipNode(tree, 0));
+}
+
+function formatOwnershipNode(node, depth) {
+    const marker = node.healthy ? '✓' : '✗';
+    const message = node.message ? ` — ${node.message}` : '';
+    const line = `${'    '.repeat(depth)}${marker} ${node.kind}/${node.name}: ${node.status}${message}`;
+    return [line, ...(node.children || []).map(child => formatOwnershipNode(child, depth + 1))].join('\n');
+}
+
+// Min/max changes are validated with a server-side dry run before applying
+async function editHPAReplicas(row) {
+    if (permissions['horizontalpodautoscalers.update'] === false) {
+        alert("You are not allowed to edit autoscalers in this namespace");
+        return;
+    }
+    const minReplicas = parseInt(prompt(`Min replicas for ${row.name}:`, row.minReplicas), 10);
+    if (!minReplicas) return;
+    const maxReplicas = parseInt(prompt(`Max replicas for ${row.name}:`, row.maxReplicas), 10);
+    if (!maxReplicas) return;
+
+    const url = `/api/v1/hpas/namespace/${row.namespace}/${row.name}/replicas`;
+    const request = {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ minReplicas, maxReplicas })
+    };
+    const dryRun = await fetch(`${url}?dryRun=true`, request);
+    const validated = await dryRun.json();
+    if (!dryRun.ok) {
+        alert(validated.error || "Error validating replicas");
+        return;
+    }
+    if (!confirm(`Set ${row.name} to ${minReplicas}-${maxReplicas} replicas?`)) return;
+
+    const response = await fetch(url, request);
+    const data = await response.json();
+    if (!response.ok) {
+        alert(data.error || "Error updating replicas");
+        return;
+    }
+    handleSearch();
+}
+
+function formatWorkloadHealth(health) {
+    if (!health) return '';
+    return `${health.healthy} ok, ${health.updating} updating, ${health.unhealthy} unhealthy`;
+}
+
+function formatLimit(limit) {
+    const values = ['min', 'max', 'default', 'defaultRequest', 'maxLimitRequestRatio']
+        .filter(key => limit[key])
+        .map(key => `${key}=${limit[key]}`);
+    return `${limit.type} ${limit.resource}: ${values.join(' ')}`;
+}
+
+function formatKeys(keys) {
+    return (keys || []).map(key => `${key.name} (${key.size}B)`).join(', ');
+}
+
+function formatUsedBy(usedBy) {
+    return (usedBy || []).map(ref => `${ref.kind}/${ref.name}`).join(', ');
+}
+
+async function showConfigMap(row) {
+    const response = await fetch(`/api/v1/configmaps/namespace/${row.namespace}/${row.name}`);
+    if (!response.ok) {
+        alert("Error fetching config map");
+        return;
+    }
+    const data = await response.json();
+    alert(Object.entries(data.data).map(([key, value]) => `${key}:\n${value}`).join("\n\n") || "No data");
+}
+
+// Secret values are only fetched on an explicit, audited reveal
+async function revealSecret(row) {
+    if (permissions['secrets.reveal'] === false) return;
+    if (!confirm(`Reveal the values of secret ${row.namespace}/${row.name}? This is recorded in the audit log.`)) return;
+    const response = await fetch(`/api/v1/secrets/namespace/${row.namespace}/${row.name}/reveal`, { method: 'POST' });
+    const data = await response.json();
+    if (!response.ok) {
+        alert(data.error || "Error revealing secret");
+        return;
+    }
+    const values = Object.entries(data.data).map(([key, value]) => `${key}:\n${value}`);
+    const binary = Object.keys(data.binaryData).map(key => `${key}: <binary>`);
+    alert(values.concat(binary).join("\n\n") || "No data");
+}
+
+// Offers to roll the workloads that consume a ConfigMap or Secret so they
+// pick up its new contents
+async function restartConsumers(path, row) {
+    const base = `/api/v1/${path}/namespace/${row.namespace}/${row.name}`;
+    const preview = await fetch(`${base}/consumers`);
+    const consumers = await preview.json();
+    if (!preview.ok) {
+        alert(consumers.error || "Error fetching consumers");
+        return;
+    }
+    if (consumers.items.length === 0) return;
+    if (!['deployments.restart', 'statefulsets.restart', 'daemonsets.restart'].some(action => permissions[action] !== false)) return;
+    const names = consumers.items.map(ref => `${ref.kind}/${ref.name}`);
+    if (!confirm(`Restart the workloads using ${row.name}?\n\n${names.join('\n')}`)) return;
+    const batchSize = parseInt(prompt("Workloads to restart per batch:", "1"), 10);
+    if (!batchSize) return;
+    const pauseSeconds = parseInt(prompt("Seconds to wait between batches:", "30"), 10) || 0;
+
+    const response = await fetch(`${base}/restart-consumers`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ batchSize, pauseSeconds, workloads: names })
+    });
+    const data = await response.json();
+    if (!response.ok) {
+        alert(data.error || "Error restarting workloads");
+        return;
+    }
+    followRestartJob(data.id);
+}
+
+// Restarts run in batches in the background; poll until the last one is done
+async function followRestartJob(id) {
+    const response = await fetch(`/api/v1/restarts/${id}`);
+    const job = await response.json();
+    if (!response.ok) {
+        elements.restartProgress.textContent = "";
+        alert(job.error || "Error following the restart");
+        return;
+    }
+    const done = job.items.filter(result => result.status !== "pending").length;
+    elements.restartProgress.textContent = `Restarting consumers of ${job.target}: ${done}/${job.items.length}`;
+    if (job.status === "Running") {
+        setTimeout(() => followRestartJob(id), 2000);
+        return;
+    }
+    elements.restartProgress.textContent = "";
+    alert(job.items.map(result => `${result.kind}/${result.name}: ${result.status}${result.error ? ' (' + result.error + ')' : ''}`).join('\n'));
+}
+
+async function handleViewSearch(namespace, view) {
+    setNextPage(null);
+    try {
+        const path = view.clusterScoped ? `/api/v1/${view.path}` : `/api/v1/${view.path}/namespace/${namespace}`;
+        const response = await fetch(`${path}${listQuery()}`);
+        if (!response.ok) {
+            await reportBadRequest(response);
+            throw new Error(`Error fetching ${view.path}`);
+        }
+        const data = await response.json();
+        reportNamespaceErrors(data.errors, data.clusterErrors);
+        resourceData = (data.items || []).map(item => ({
+            ...item,
+            labels: transformLabels(item.labels)
+        }));
+        hot.updateSettings(withClusterColumn({
+            colHeaders: view.colHeaders,
+            columns: view.columns,
+            cells(row) {
+                const resource = resourceData[row];
+                return resource && view.isUnhealthy(resource) ? { className: 'updating-row' } : {};
+            }
+        }));
+        openRow = view.open || null;
+        updateHandsontable();
+    } catch (error) {
+        console.error("Search error:", error);
+        alert("Error fetching resources");
+    }
+}
+
+async function loadContexts() {
+    try {
+        const response = await fetch("/api/v1/contexts");
+        if (!response.ok) throw new Error("Error fetching contexts");
+        const contexts = await response.json();
+        elements.kubeContext.innerHTML = "";
+        contexts.forEach(context => {
+            const option = document.createElement("option");
+            option.value = context.name;
+            option.textContent = `${context.name} (${context.cluster})`;
+            option.selected = context.active;
+            elements.kubeContext.appendChild(option);
+        });
+    } catch (error) {
+        console.error("Contexts error:", error);
+    }
+}
+
+// Adds the contexts of another kubeconfig to the session
+async function addKubeconfig(event) {
+    const formData = new FormData();
+    formData.append('kubeconfig', event.target.files[0]);
+    formData.append('append', 'true');
+    event.target.value = '';
+    const response = await fetch('/upload', { method: 'POST', body: formData });
+    const data = await response.json();
+    if (!response.ok) {
+        alert(data.error || "Error adding kubeconfig");
+        return;
+    }
+    const warnings = (data.warnings || []).map(warning => `\n${warning}`).join('');
+    alert(`Added contexts: ${data.contexts.join(', ')}${warnings}`);
+    await loadContexts();
+}
+
+// Switching context reloads the namespaces of the new cluster and selects the
+// context's default namespace
+async function switchContext(name) {
+    const response = await fetch("/api/v1/contexts/switch", {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ context: name })
+    });
+    const data = await response.json();
+    if (!response.ok) {
+        alert(data.error || "Error switching context");
+        await loadContexts();
+        return;
+    }
+    displayUsername(data.user);
+    showCredentialWarning(data.warning);
+    ui.populateNamespaces(await fetchNamespaces());
+    elements.namespace.value = data.namespace || ALL_NAMESPACES;
+    await handleSearch();
 }
 
 // Fetch Namespaces
@@ -135,12 +803,17 @@ async function fetchNamespaces() {
 }
 
 // Fetch Deployments with label transformation
-async function fetchDeployments(namespace) {
+async function fetchDeployments(namespace, extra = {}) {
     try {
-        const response = await fetch(`/api/v1/deployments/namespace/${namespace}`);
-        if (!response.ok) throw new Error("Error fetching deployments");
+        const response = await fetch(`/api/v1/deployments/namespace/${namespace}${listQuery(extra)}`);
+        if (!response.ok) {
+            await reportBadRequest(response);
+            throw new Error("Error fetching deployments");
+        }
         const data = await response.json();
-        return data.map(item => ({
+        reportNamespaceErrors(data.errors, data.clusterErrors);
+        setNextPage(data.continue);
+        return (data.items || []).map(item => ({
             ...item,
             labels: transformLabels(item.labels)
         }));
@@ -151,12 +824,17 @@ async function fetchDeployments(namespace) {
 }
 
 // Fetch StatefulSets with label transformation
-async function fetchStatefulSets(namespace) {
+async function fetchStatefulSets(namespace, extra = {}) {
     try {
-        const response = await fetch(`/api/v1/statefulsets/namespace/${namespace}`);
-        if (!response.ok) throw new Error("Error fetching stateful sets");
+        const response = await fetch(`/api/v1/statefulsets/namespace/${namespace}${listQuery(extra)}`);
+        if (!response.ok) {
+            await reportBadRequest(response);
+            throw new Error("Error fetching stateful sets");
+        }
         const data = await response.json();
-        return data.map(item => ({
+        reportNamespaceErrors(data.errors, data.clusterErrors);
+        setNextPage(data.continue);
+        return (data.items || []).map(item => ({
             ...item,
             labels: transformLabels(item.labels)
         }));
@@ -167,12 +845,17 @@ async function fetchStatefulSets(namespace) {
 }
 
 // Fetch Pods with label transformation
-async function fetchPods(namespace) {
+async function fetchPods(namespace, extra = {}) {
     try {
-        const response = await fetch(`/api/v1/pods/namespace/${namespace}`);
-        if (!response.ok) throw new Error("Error fetching pods");
+        const response = await fetch(`/api/v1/pods/namespace/${namespace}${listQuery(extra)}`);
+        if (!response.ok) {
+            await reportBadRequest(response);
+            throw new Error("Error fetching pods");
+        }
         const data = await response.json();
-        return data.map(item => ({
+        reportNamespaceErrors(data.errors, data.clusterErrors);
+        setNextPage(data.continue);
+        return (data.items || []).map(item => ({
             ...item,
             labels: transformLabels(item.labels)
         }));
@@ -182,6 +865,48 @@ async function fetchPods(namespace) {
     }
 }
 
+// Query string shared by the list endpoints; the label search box is sent as a
+// Kubernetes label selector (e.g. "app=web,tier!=cache")
+function listQuery(extra = {}) {
+    const params = new URLSearchParams(extra);
+    const labelSelector = elements.labelSearch.value.trim();
+    if (labelSelector) params.set("labelSelector", labelSelector);
+    // Results from every cluster come back in one response, without pages
+    if (elements.allClusters.checked) {
+        params.delete("limit");
+        params.delete("continue");
+        params.set("clusters", "_all");
+    }
+    const query = params.toString();
+    return query ? `?${query}` : "";
+}
+
+// Surface selector validation errors instead of showing an empty table
+async function reportBadRequest(response) {
+    if (response.status !== 400) return;
+    const data = await response.json().catch(() => ({}));
+    alert(data.error || "Invalid request");
+}
+
+// List endpoints return partial results when some namespaces or clusters
+// could not be read
+function reportNamespaceErrors(errors, clusterErrors) {
+    const entries = Object.entries(errors || {}).concat(Object.entries(clusterErrors || {}));
+    if (entries.length === 0) return;
+    console.warn("Some namespaces could not be listed:", errors, clusterErrors);
+    alert(`Some namespaces could not be listed:\n${entries.map(([ns, msg]) => `${ns}: ${msg}`).join("\n")}`);
+}
+
+// Adds a leading Cluster column when searching across clusters
+function withClusterColumn(settings) {
+    if (!elements.allClusters.checked) return settings;
+    return {
+        ...settings,
+        colHeaders: ['Cluster', ...settings.colHeaders],
+        columns: [{ data: 'cluster', readOnly: true, width: 120 }, ...settings.columns]
+    };
+}
+
 // Transform labels object to array of "key:value" strings
 function transformLabels(labels) {
     return Object.entries(labels || {}).map(([key, value]) => `${key}:${value}`);
@@ -244,10 +969,16 @@ async function performRolloutRestart() {
 
     if (!confirm(`Restart ${visibleData.length} selected resources?`)) return;
 
+    // Restarts refused for lack of quota are reported with the reason
+    const refused = [];
     const promises = visibleData.map(async resource => {
         const endpoint = `/api/v1/${resource.resourceType.toLowerCase()}s/${resource.namespace}/rollout/${resource.name}`;
         try {
             const response = await fetch(endpoint, { method: 'POST' });
+            if (response.status === 409) {
+                const data = await response.json();
+                refused.push(`${resource.name}: ${data.error}`);
+            }
             return response.ok;
         } catch (error) {
             console.error("Restart failed:", error);
@@ -258,7 +989,8 @@ async function performRolloutRestart() {
     const results = await Promise.all(promises);
     const successCount = results.filter(status => status).length;
     
-    alert(`Successfully restarted ${successCount}/${visibleData.length} resources`);
+    const details = refused.length > 0 ? `\n\n${refused.join('\n')}` : '';
+    alert(`Successfully restarted ${successCount}/${visibleData.length} resources${details}`);
     await handleSearch();
 }
 
@@ -277,6 +1009,7 @@ function applyStatusHighlighting() {
 }
 
 function isResourceUpdating(resource, resourceType) {
+    if (!resource.ready) return false;
     switch (resourceType) {
         case "deployment":
             const [readyDeploy, totalDeploy] = resource.ready.split('/').map(Number);
@@ -299,6 +1032,11 @@ async function initialize() {
     initializeHandsontable();
     setupEventListeners();
     displayUsername(username);
+    await loadContexts();
+    // The landing page shows the health of every namespace
+    elements.namespace.value = ALL_NAMESPACES;
+    elements.resourceType.value = "summary";
+    await handleSearch();
 }
 
 document.addEventListener("DOMContentLoaded", initialize);
\ No newline at end of file